@@ -0,0 +1,86 @@
+// Package auth decides who may join a game. The server hands every join's
+// credentials to an Authenticator before the player enters the world.
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	METHOD_NONE     = ""
+	METHOD_PASSWORD = "password"
+	METHOD_TOKEN    = "token"
+
+	MOCK_TOKEN_PREFIX = "mock:"
+)
+
+var ErrDenied = errors.New("access denied")
+
+// Credentials are what a client sent along with join.
+type Credentials struct {
+	Room     string
+	Name     string
+	Password string
+	Token    string
+}
+
+// Identity is who an Authenticator says the client is. When Name is set it
+// replaces the name the client asked for, since the provider knows better.
+type Identity struct {
+	Subject string
+	Name    string
+}
+
+type Authenticator interface {
+	Authenticate(credentials Credentials) (Identity, error)
+	// Method tells clients what to send with join: nothing, a password or
+	// a token.
+	Method() string
+}
+
+// None lets everyone in under the name they ask for.
+type None struct{}
+
+func (None) Authenticate(credentials Credentials) (Identity, error) {
+	return Identity{}, nil
+}
+
+func (None) Method() string {
+	return METHOD_NONE
+}
+
+// SharedPassword lets in anyone who knows the arena's password, under the
+// name they ask for.
+type SharedPassword struct {
+	Password string
+}
+
+func (p SharedPassword) Authenticate(credentials Credentials) (Identity, error) {
+	if subtle.ConstantTimeCompare([]byte(credentials.Password), []byte(p.Password)) != 1 {
+		return Identity{}, fmt.Errorf("%w: wrong password", ErrDenied)
+	}
+	return Identity{}, nil
+}
+
+func (SharedPassword) Method() string {
+	return METHOD_PASSWORD
+}
+
+// Mock stands in for the portal when testing locally: the token
+// "mock:<name>" logs in as name, anything else is refused.
+type Mock struct{}
+
+func (Mock) Authenticate(credentials Credentials) (Identity, error) {
+	name, ok := strings.CutPrefix(credentials.Token, MOCK_TOKEN_PREFIX)
+	if !ok || name == "" {
+		return Identity{}, fmt.Errorf("%w: mock tokens look like %s<name>", ErrDenied, MOCK_TOKEN_PREFIX)
+	}
+	return Identity{Subject: credentials.Token, Name: name}, nil
+}
+
+func (Mock) Method() string {
+	return METHOD_TOKEN
+}
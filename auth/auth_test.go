@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSharedPassword(t *testing.T) {
+	p := SharedPassword{Password: "hunter2"}
+	if _, err := p.Authenticate(Credentials{Name: "ana", Password: "hunter2"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Authenticate(Credentials{Name: "ana", Password: "hunter3"}); !errors.Is(err, ErrDenied) {
+		t.Fatalf("wrong password: got %v, want ErrDenied", err)
+	}
+}
+
+func TestMock(t *testing.T) {
+	identity, err := Mock{}.Authenticate(Credentials{Name: "whoever", Token: "mock:ana"})
+	if err != nil || identity.Name != "ana" {
+		t.Fatalf("identity %+v, error %v", identity, err)
+	}
+	for _, token := range []string{"", "mock:", "ana"} {
+		if _, err := (Mock{}).Authenticate(Credentials{Token: token}); !errors.Is(err, ErrDenied) {
+			t.Fatalf("token %q: got %v, want ErrDenied", token, err)
+		}
+	}
+}
+
+func TestHMACToken(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	secret := []byte("portal-secret")
+	h := HMACToken{Secret: secret, Issuer: "portal", Now: func() time.Time { return now }}
+
+	identity, err := h.Authenticate(Credentials{Token: SignToken(secret, "portal", "u-42", "ana", now.Add(time.Hour))})
+	if err != nil || identity.Subject != "u-42" || identity.Name != "ana" {
+		t.Fatalf("identity %+v, error %v", identity, err)
+	}
+	identity, err = h.Authenticate(Credentials{Token: SignToken(secret, "portal", "bia", "", now.Add(time.Hour))})
+	if err != nil || identity.Name != "bia" {
+		t.Fatalf("name should fall back to the subject: identity %+v, error %v", identity, err)
+	}
+
+	valid := SignToken(secret, "portal", "u-42", "ana", now.Add(time.Hour))
+	parts := strings.Split(valid, ".")
+	tests := []struct {
+		name    string
+		token   string
+		wantErr string
+	}{
+		{name: "garbage", token: "not-a-token", wantErr: "not a JWT"},
+		{name: "other secret", token: SignToken([]byte("guess"), "portal", "u-42", "ana", now.Add(time.Hour)), wantErr: "signature"},
+		{name: "tampered claims", token: parts[0] + "." + parts[0] + "." + parts[2], wantErr: "signature"},
+		{name: "expired", token: SignToken(secret, "portal", "u-42", "ana", now), wantErr: "expired"},
+		{name: "other issuer", token: SignToken(secret, "elsewhere", "u-42", "ana", now.Add(time.Hour)), wantErr: "issued by"},
+		{name: "alg none", token: "eyJhbGciOiJub25lIn0." + parts[1] + ".", wantErr: "algorithm"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := h.Authenticate(Credentials{Token: tt.token})
+			if !errors.Is(err, ErrDenied) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want a denial mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUserFile(t *testing.T) {
+	hash, err := HashPassword("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "users")
+	if err := os.WriteFile(path, []byte("# arena users\nana:"+hash+"\n\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	users, err := LoadUserFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := users.Authenticate(Credentials{Name: "ana", Password: "s3cret"})
+	if err != nil || identity.Name != "ana" {
+		t.Fatalf("identity %+v, error %v", identity, err)
+	}
+	if _, err := users.Authenticate(Credentials{Name: "ana", Password: "wrong"}); !errors.Is(err, ErrDenied) {
+		t.Fatalf("wrong password: got %v", err)
+	}
+	if _, err := users.Authenticate(Credentials{Name: "bia", Password: ""}); !errors.Is(err, ErrDenied) {
+		t.Fatalf("unknown user: got %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("ana:plaintext\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadUserFile(path); err == nil || !strings.Contains(err.Error(), ":1:") {
+		t.Fatalf("bad hash: got %v, want an error pointing at line 1", err)
+	}
+}
@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	HASH_SCHEME       = "pbkdf2-sha256"
+	HASH_ITERATIONS   = 600000
+	HASH_SALT_BYTES   = 16
+	HASH_KEY_BYTES    = 32
+	USER_FILE_COMMENT = "#"
+)
+
+// UserFile lets in the users listed in a file, each under their own name.
+// Every line holds "name:hash", with hashes made by HashPassword.
+type UserFile struct {
+	users map[string]string
+	// decoy is checked for unknown users so they take as long as known ones.
+	decoy string
+}
+
+func LoadUserFile(path string) (*UserFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, USER_FILE_COMMENT) {
+			continue
+		}
+		name, hash, ok := strings.Cut(text, ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%s:%d: want name:hash", path, line)
+		}
+		if _, _, _, err := parseHash(hash); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		users[name] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	decoy, err := HashPassword("")
+	if err != nil {
+		return nil, err
+	}
+	return &UserFile{users: users, decoy: decoy}, nil
+}
+
+func (u *UserFile) Authenticate(credentials Credentials) (Identity, error) {
+	hash, known := u.users[credentials.Name]
+	if !known {
+		hash = u.decoy
+	}
+	if !checkPassword(hash, credentials.Password) || !known {
+		return Identity{}, fmt.Errorf("%w: wrong user name or password", ErrDenied)
+	}
+	return Identity{Subject: credentials.Name, Name: credentials.Name}, nil
+}
+
+func (*UserFile) Method() string {
+	return METHOD_PASSWORD
+}
+
+// HashPassword hashes password for a user file line.
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, HASH_SALT_BYTES)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, HASH_ITERATIONS, HASH_KEY_BYTES)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s$%d$%s$%s", HASH_SCHEME, HASH_ITERATIONS,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func parseHash(hash string) (iterations int, salt, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != HASH_SCHEME {
+		return 0, nil, nil, fmt.Errorf("hash must look like %s$iterations$salt$key", HASH_SCHEME)
+	}
+	if iterations, err = strconv.Atoi(parts[1]); err != nil || iterations < 1 {
+		return 0, nil, nil, fmt.Errorf("bad iteration count %q", parts[1])
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[2]); err != nil {
+		return 0, nil, nil, fmt.Errorf("bad salt: %v", err)
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[3]); err != nil {
+		return 0, nil, nil, fmt.Errorf("bad key: %v", err)
+	}
+	return iterations, salt, key, nil
+}
+
+func checkPassword(hash, password string) bool {
+	iterations, salt, key, err := parseHash(hash)
+	if err != nil {
+		return false
+	}
+	got, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(key))
+	return err == nil && subtle.ConstantTimeCompare(got, key) == 1
+}
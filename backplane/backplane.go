@@ -0,0 +1,101 @@
+// Package backplane carries messages between server instances. Publish
+// sends a message to every instance subscribed to its channel, including
+// the sender. Memory does it within one process; Redis does it across
+// processes through Redis or anything speaking its pub/sub protocol, like
+// the StandIn.
+package backplane
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+)
+
+const (
+	SCHEME_MEMORY = "memory"
+	SCHEME_REDIS  = "redis"
+)
+
+type Backplane interface {
+	Publish(channel string, message []byte) error
+	// Subscribe calls handler with every message published on channel from
+	// then on, one at a time and in order, until Close.
+	Subscribe(channel string, handler func(message []byte)) error
+	Close() error
+}
+
+// Open connects to the backplane at rawURL: "memory" or "memory:" for a
+// process-local one, or redis://host:port.
+func Open(rawURL string) (Backplane, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case rawURL == SCHEME_MEMORY || u.Scheme == SCHEME_MEMORY:
+		return NewMemory(), nil
+	case u.Scheme == SCHEME_REDIS:
+		if u.Host == "" {
+			return nil, fmt.Errorf("%q has no host", rawURL)
+		}
+		return DialRedis(u.Host)
+	}
+	return nil, fmt.Errorf("unsupported backplane %q; use %s or %s://host:port", rawURL, SCHEME_MEMORY, SCHEME_REDIS)
+}
+
+// Memory is a backplane for a single process. Handlers run in the
+// publisher's goroutine, one publish per channel at a time, so a handler
+// must not publish on its own channel, nor while holding a lock that
+// another handler takes.
+type Memory struct {
+	handlers map[string][]func([]byte)
+	// deliveries serializes the publishes on each channel.
+	deliveries map[string]*sync.Mutex
+	closed     bool
+	mutex      sync.RWMutex
+}
+
+func NewMemory() *Memory {
+	return &Memory{handlers: make(map[string][]func([]byte)), deliveries: make(map[string]*sync.Mutex)}
+}
+
+func (m *Memory) Publish(channel string, message []byte) error {
+	m.mutex.RLock()
+	if m.closed {
+		m.mutex.RUnlock()
+		return fmt.Errorf("backplane closed")
+	}
+	handlers, delivery := m.handlers[channel], m.deliveries[channel]
+	m.mutex.RUnlock()
+	if delivery == nil {
+		return nil
+	}
+
+	delivery.Lock()
+	defer delivery.Unlock()
+	for _, handler := range handlers {
+		handler(append([]byte(nil), message...))
+	}
+	return nil
+}
+
+func (m *Memory) Subscribe(channel string, handler func(message []byte)) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.closed {
+		return fmt.Errorf("backplane closed")
+	}
+	m.handlers[channel] = append(m.handlers[channel], handler)
+	if m.deliveries[channel] == nil {
+		m.deliveries[channel] = &sync.Mutex{}
+	}
+	return nil
+}
+
+func (m *Memory) Close() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.closed = true
+	m.handlers, m.deliveries = nil, nil
+	return nil
+}
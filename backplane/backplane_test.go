@@ -0,0 +1,162 @@
+package backplane
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func TestMemory(t *testing.T) {
+	bp := NewMemory()
+	var got [][]byte
+	bp.Subscribe("chat", func(message []byte) { got = append(got, message) })
+	bp.Subscribe("other", func(message []byte) { t.Error("message delivered to the wrong channel") })
+
+	for _, message := range []string{"one", "two"} {
+		if err := bp.Publish("chat", []byte(message)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(got) != 2 || string(got[0]) != "one" || string(got[1]) != "two" {
+		t.Fatalf("received %q", got)
+	}
+
+	bp.Close()
+	if err := bp.Publish("chat", []byte("late")); err == nil {
+		t.Fatal("published after Close")
+	}
+}
+
+func TestMemoryConcurrentPublish(t *testing.T) {
+	const publishers = 8
+	const messages = 200
+
+	bp := NewMemory()
+	var delivering atomic.Int32
+	var got [2][]string
+	for i := range got {
+		bp.Subscribe("chat", func(message []byte) {
+			if delivering.Add(1) > 1 {
+				t.Error("handlers ran concurrently")
+			}
+			// Give another publisher the chance to overlap.
+			runtime.Gosched()
+			got[i] = append(got[i], string(message))
+			delivering.Add(-1)
+		})
+	}
+
+	var wg sync.WaitGroup
+	for p := 0; p < publishers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for n := 0; n < messages; n++ {
+				bp.Publish("chat", []byte(fmt.Sprintf("%d:%d", p, n)))
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	if len(got[0]) != publishers*messages {
+		t.Fatalf("received %d messages, want %d", len(got[0]), publishers*messages)
+	}
+	next := make(map[int]int, publishers)
+	for i, message := range got[0] {
+		if message != got[1][i] {
+			t.Fatalf("handlers disagree at message %d: %q and %q", i, message, got[1][i])
+		}
+		var p, n int
+		fmt.Sscanf(message, "%d:%d", &p, &n)
+		if n != next[p] {
+			t.Fatalf("publisher %d's message %d arrived when %d was due", p, n, next[p])
+		}
+		next[p]++
+	}
+}
+
+func startStandIn(t *testing.T, addr string) *StandIn {
+	t.Helper()
+
+	standIn, err := ListenStandIn(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go standIn.Serve()
+	return standIn
+}
+
+// publishUntilReceived publishes message until it arrives on received,
+// since subscriptions over the network take effect asynchronously.
+func publishUntilReceived(t *testing.T, bp Backplane, channel string, message []byte, received <-chan []byte) {
+	t.Helper()
+
+	deadline := time.After(5 * time.Second)
+	for {
+		if err := bp.Publish(channel, message); err != nil {
+			t.Logf("publish: %v", err)
+		}
+		select {
+		case got := <-received:
+			if !bytes.Equal(got, message) {
+				t.Fatalf("received %q, want %q", got, message)
+			}
+			return
+		case <-time.After(50 * time.Millisecond):
+		case <-deadline:
+			t.Fatalf("%q never arrived", message)
+		}
+	}
+}
+
+func TestRedisThroughStandIn(t *testing.T) {
+	standIn := startStandIn(t, "127.0.0.1:0")
+	addr := standIn.Addr().String()
+
+	publisher, err := Open("redis://" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Close()
+	subscriber, err := DialRedis(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer subscriber.Close()
+
+	received := make(chan []byte, 100)
+	subscriber.Subscribe("arena:chat", func(message []byte) { received <- message })
+	publishUntilReceived(t, publisher, "arena:chat", []byte("binary\r\n$3\r\nsafe"), received)
+
+	// Restart the stand-in: both connections drop and must come back.
+	standIn.Close()
+	standIn = startStandIn(t, addr)
+	defer standIn.Close()
+	publishUntilReceived(t, publisher, "arena:chat", []byte("after restart"), received)
+}
+
+func TestOpen(t *testing.T) {
+	bp, err := Open("memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bp.Close()
+
+	for _, url := range []string{"kafka://broker", "redis://"} {
+		if _, err := Open(url); err == nil {
+			t.Fatalf("%q accepted", url)
+		}
+	}
+}
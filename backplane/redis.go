@@ -0,0 +1,223 @@
+package backplane
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	DIAL_TIMEOUT    = 5 * time.Second
+	REPLY_TIMEOUT   = 5 * time.Second
+	RECONNECT_DELAY = time.Second
+)
+
+var errClosed = errors.New("backplane closed")
+
+// Redis is a backplane client for Redis pub/sub. It keeps one connection
+// for publishing and one in subscribe mode, and redials either when it
+// drops; messages published while the subscriber is down are lost, as with
+// Redis itself.
+type Redis struct {
+	addr string
+
+	pubMutex sync.Mutex
+	pub      net.Conn
+	pubR     *bufio.Reader
+	pubW     *bufio.Writer
+
+	subMutex sync.Mutex
+	sub      net.Conn
+	subW     *bufio.Writer
+	handlers map[string][]func([]byte)
+
+	closed chan struct{}
+	done   chan struct{}
+}
+
+// DialRedis connects to the server at addr.
+func DialRedis(addr string) (*Redis, error) {
+	r := &Redis{
+		addr:     addr,
+		handlers: make(map[string][]func([]byte)),
+		closed:   make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if err := r.dialPublisher(); err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("tcp", addr, DIAL_TIMEOUT)
+	if err != nil {
+		r.pub.Close()
+		return nil, err
+	}
+	r.sub, r.subW = conn, bufio.NewWriter(conn)
+	go r.receive(conn)
+	return r, nil
+}
+
+func (r *Redis) dialPublisher() error {
+	conn, err := net.DialTimeout("tcp", r.addr, DIAL_TIMEOUT)
+	if err != nil {
+		return err
+	}
+	r.pub, r.pubR, r.pubW = conn, bufio.NewReader(conn), bufio.NewWriter(conn)
+	return nil
+}
+
+func (r *Redis) Publish(channel string, message []byte) error {
+	r.pubMutex.Lock()
+	defer r.pubMutex.Unlock()
+
+	select {
+	case <-r.closed:
+		return errClosed
+	default:
+	}
+	if r.pub == nil {
+		if err := r.dialPublisher(); err != nil {
+			return err
+		}
+	}
+
+	r.pub.SetDeadline(time.Now().Add(REPLY_TIMEOUT))
+	err := writeCommand(r.pubW, []byte("PUBLISH"), []byte(channel), message)
+	var reply interface{}
+	if err == nil {
+		reply, err = readValue(r.pubR)
+	}
+	if err != nil {
+		// The connection is in an unknown state; start over next time.
+		r.pub.Close()
+		r.pub = nil
+		return err
+	}
+	if replyErr, ok := reply.(respError); ok {
+		return replyErr
+	}
+	return nil
+}
+
+func (r *Redis) Subscribe(channel string, handler func(message []byte)) error {
+	r.subMutex.Lock()
+	defer r.subMutex.Unlock()
+
+	select {
+	case <-r.closed:
+		return errClosed
+	default:
+	}
+	first := len(r.handlers[channel]) == 0
+	r.handlers[channel] = append(r.handlers[channel], handler)
+	if first && r.sub != nil {
+		// A failed write shows up in receive, which resubscribes.
+		writeCommand(r.subW, []byte("SUBSCRIBE"), []byte(channel))
+	}
+	return nil
+}
+
+// receive dispatches messages from conn until it fails, then redials and
+// resubscribes until Close.
+func (r *Redis) receive(conn net.Conn) {
+	defer close(r.done)
+
+	for {
+		reader := bufio.NewReader(conn)
+		for {
+			value, err := readValue(reader)
+			if err != nil {
+				break
+			}
+			r.dispatch(value)
+		}
+		conn.Close()
+
+		for {
+			select {
+			case <-r.closed:
+				return
+			case <-time.After(RECONNECT_DELAY):
+			}
+			var err error
+			if conn, err = r.resubscribe(); err == nil {
+				log.Printf("Reconnected to backplane at %s", r.addr)
+				break
+			}
+			log.Printf("Backplane at %s unreachable: %v", r.addr, err)
+		}
+	}
+}
+
+func (r *Redis) resubscribe() (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", r.addr, DIAL_TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+
+	r.subMutex.Lock()
+	defer r.subMutex.Unlock()
+	r.sub, r.subW = conn, bufio.NewWriter(conn)
+	if len(r.handlers) == 0 {
+		return conn, nil
+	}
+	args := [][]byte{[]byte("SUBSCRIBE")}
+	for channel := range r.handlers {
+		args = append(args, []byte(channel))
+	}
+	if err := writeCommand(r.subW, args...); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (r *Redis) dispatch(value interface{}) {
+	parts, ok := value.([]interface{})
+	if !ok || len(parts) != 3 {
+		return
+	}
+	kind, _ := parts[0].([]byte)
+	channel, _ := parts[1].([]byte)
+	message, isMessage := parts[2].([]byte)
+	if string(kind) != "message" || !isMessage {
+		return
+	}
+
+	r.subMutex.Lock()
+	handlers := r.handlers[string(channel)]
+	r.subMutex.Unlock()
+	for _, handler := range handlers {
+		handler(message)
+	}
+}
+
+func (r *Redis) Close() error {
+	select {
+	case <-r.closed:
+		return nil
+	default:
+	}
+	close(r.closed)
+
+	r.pubMutex.Lock()
+	if r.pub != nil {
+		r.pub.Close()
+	}
+	r.pubMutex.Unlock()
+	r.subMutex.Lock()
+	if r.sub != nil {
+		r.sub.Close()
+	}
+	r.subMutex.Unlock()
+
+	select {
+	case <-r.done:
+	case <-time.After(REPLY_TIMEOUT):
+		return fmt.Errorf("backplane receiver did not stop")
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package backplane
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// MAX_BULK_LENGTH bounds the strings read off the wire, so a confused peer
+// cannot make us allocate without limit.
+const MAX_BULK_LENGTH = 1 << 20
+
+// respError is an error reply from the server.
+type respError string
+
+func (e respError) Error() string {
+	return string(e)
+}
+
+// writeCommand writes args as a RESP array of bulk strings, the form every
+// request takes.
+func writeCommand(w *bufio.Writer, args ...[]byte) error {
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(w, "$%d\r\n", len(arg))
+		w.Write(arg)
+		w.WriteString("\r\n")
+	}
+	return w.Flush()
+}
+
+// readValue reads one RESP value: a string or error for simple strings and
+// errors, an int64, a []byte (nil for a null bulk string) or an
+// []interface{} of those.
+func readValue(r *bufio.Reader) (interface{}, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("empty RESP line")
+	}
+
+	switch line[0] {
+	case '+':
+		return string(line[1:]), nil
+	case '-':
+		return respError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(string(line[1:]), 10, 64)
+	case '$':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil || n > MAX_BULK_LENGTH {
+			return nil, fmt.Errorf("bad bulk length %q", line[1:])
+		}
+		if n < 0 {
+			return []byte(nil), nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil || n > MAX_BULK_LENGTH {
+			return nil, fmt.Errorf("bad array length %q", line[1:])
+		}
+		values := make([]interface{}, 0, max(n, 0))
+		for i := 0; i < n; i++ {
+			value, err := readValue(r)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("unknown RESP type %q", line[0])
+}
+
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, errors.New("RESP line does not end in CRLF")
+	}
+	return line[:len(line)-2], nil
+}
+
+// readCommand reads a request, which must be an array of bulk strings.
+func readCommand(r *bufio.Reader) ([][]byte, error) {
+	value, err := readValue(r)
+	if err != nil {
+		return nil, err
+	}
+	values, ok := value.([]interface{})
+	if !ok || len(values) == 0 {
+		return nil, errors.New("commands must be non-empty arrays")
+	}
+	args := make([][]byte, len(values))
+	for i, v := range values {
+		if args[i], ok = v.([]byte); !ok {
+			return nil, errors.New("command arguments must be bulk strings")
+		}
+	}
+	return args, nil
+}
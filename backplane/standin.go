@@ -0,0 +1,167 @@
+package backplane
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+)
+
+// StandIn is a minimal Redis pub/sub server for running several instances
+// locally without Redis. It understands PING, PUBLISH, SUBSCRIBE,
+// UNSUBSCRIBE and QUIT.
+type StandIn struct {
+	listener    net.Listener
+	clients     map[*standInClient]bool
+	subscribers map[string]map[*standInClient]bool
+	mutex       sync.Mutex
+}
+
+type standInClient struct {
+	conn   net.Conn
+	w      *bufio.Writer
+	mutex  sync.Mutex
+	topics map[string]bool
+}
+
+// ListenStandIn starts a stand-in on addr; Serve accepts clients.
+func ListenStandIn(addr string) (*StandIn, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &StandIn{
+		listener:    listener,
+		clients:     make(map[*standInClient]bool),
+		subscribers: make(map[string]map[*standInClient]bool),
+	}, nil
+}
+
+func (s *StandIn) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Serve accepts clients until Close.
+func (s *StandIn) Serve() error {
+	for {
+		conn, err := s.listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		go s.serveClient(conn)
+	}
+}
+
+// Close stops accepting clients and disconnects the current ones.
+func (s *StandIn) Close() error {
+	err := s.listener.Close()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for client := range s.clients {
+		client.conn.Close()
+	}
+	return err
+}
+
+func (s *StandIn) serveClient(conn net.Conn) {
+	client := &standInClient{conn: conn, w: bufio.NewWriter(conn), topics: make(map[string]bool)}
+	s.mutex.Lock()
+	s.clients[client] = true
+	s.mutex.Unlock()
+	defer func() {
+		s.unsubscribe(client, nil)
+		s.mutex.Lock()
+		delete(s.clients, client)
+		s.mutex.Unlock()
+		conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		switch command := strings.ToUpper(string(args[0])); {
+		case command == "PING":
+			client.reply("+PONG\r\n")
+		case command == "QUIT":
+			client.reply("+OK\r\n")
+			return
+		case command == "PUBLISH" && len(args) == 3:
+			client.reply(fmt.Sprintf(":%d\r\n", s.publish(string(args[1]), args[2])))
+		case command == "SUBSCRIBE" && len(args) > 1:
+			s.subscribe(client, args[1:])
+		case command == "UNSUBSCRIBE":
+			s.unsubscribe(client, args[1:])
+		default:
+			client.reply(fmt.Sprintf("-ERR unsupported command '%s'\r\n", command))
+		}
+	}
+}
+
+func (c *standInClient) reply(raw string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.w.WriteString(raw)
+	c.w.Flush()
+}
+
+func (c *standInClient) send(args ...[]byte) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return writeCommand(c.w, args...)
+}
+
+func (s *StandIn) publish(channel string, message []byte) int {
+	s.mutex.Lock()
+	clients := make([]*standInClient, 0, len(s.subscribers[channel]))
+	for client := range s.subscribers[channel] {
+		clients = append(clients, client)
+	}
+	s.mutex.Unlock()
+
+	for _, client := range clients {
+		if err := client.send([]byte("message"), []byte(channel), message); err != nil {
+			log.Printf("Dropping backplane subscriber %s: %v", client.conn.RemoteAddr(), err)
+			client.conn.Close()
+		}
+	}
+	return len(clients)
+}
+
+func (s *StandIn) subscribe(client *standInClient, channels [][]byte) {
+	for _, channel := range channels {
+		s.mutex.Lock()
+		if s.subscribers[string(channel)] == nil {
+			s.subscribers[string(channel)] = make(map[*standInClient]bool)
+		}
+		s.subscribers[string(channel)][client] = true
+		client.topics[string(channel)] = true
+		count := len(client.topics)
+		s.mutex.Unlock()
+		client.reply(fmt.Sprintf("*3\r\n$9\r\nsubscribe\r\n$%d\r\n%s\r\n:%d\r\n", len(channel), channel, count))
+	}
+}
+
+// unsubscribe removes client from channels, or from all of them when
+// channels is empty.
+func (s *StandIn) unsubscribe(client *standInClient, channels [][]byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(channels) == 0 {
+		for channel := range client.topics {
+			channels = append(channels, []byte(channel))
+		}
+	}
+	for _, channel := range channels {
+		delete(s.subscribers[string(channel)], client)
+		delete(client.topics, string(channel))
+	}
+}
@@ -0,0 +1,578 @@
+// Package config builds the server configuration from, in increasing
+// priority, the engine defaults, a JSON file, ARENA_* environment variables
+// and command-line flags. Rooms in the file can override any game knob.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"multiplayer-game/auth"
+	"multiplayer-game/backplane"
+	"multiplayer-game/engine"
+	"multiplayer-game/server"
+)
+
+const (
+	ENV_PREFIX        = "ARENA_"
+	DEFAULT_ADDR      = ":3000"
+	DEFAULT_BACKPLANE = backplane.SCHEME_MEMORY
+)
+
+// Auth providers.
+const (
+	AUTH_NONE      = "none"
+	AUTH_PASSWORD  = "password"
+	AUTH_TOKEN     = "token"
+	AUTH_USER_FILE = "userFile"
+	AUTH_MOCK      = "mock"
+)
+
+type Config struct {
+	Addr string
+	// AdminToken enables the admin endpoints for requests bearing it.
+	AdminToken string
+	// SnapshotPath is where the match is saved on shutdown and resumed from
+	// on start; empty disables snapshots.
+	SnapshotPath string
+	// TLSCert and TLSKey are the certificate and key files to serve HTTPS
+	// with. DevTLS serves it with a self-signed certificate instead.
+	TLSCert string
+	TLSKey  string
+	DevTLS  bool
+	// AllowedOrigins lists the origins, besides the server's own, that may
+	// open WebSocket connections; "*" allows any.
+	AllowedOrigins []string
+	// InstanceID names this instance to the others on the backplane and
+	// PublicURL is where clients reach it; both have defaults worked out at
+	// start when empty.
+	InstanceID string
+	PublicURL  string
+	// HostedRooms lists the rooms this instance runs; empty runs them all.
+	HostedRooms []string
+	// Backplane is the URL of the backplane shared with the other
+	// instances, and ServeBackplane an address to run a stand-in for one on.
+	Backplane      string
+	ServeBackplane string
+	// Auth picks who may join. Unlike the other server settings it is
+	// applied again on reload.
+	Auth Auth
+	// Game holds the knobs every room starts from.
+	Game engine.Config
+	// Rooms holds each room's knobs after its overrides; it always
+	// includes server.DEFAULT_ROOM.
+	Rooms map[string]engine.Config
+}
+
+type Auth struct {
+	// Provider is one of the AUTH_* providers.
+	Provider string
+	// Password is the shared password for AUTH_PASSWORD.
+	Password string
+	// Secret and Issuer check AUTH_TOKEN tokens; Issuer is optional.
+	Secret string
+	Issuer string
+	// UserFile lists the AUTH_USER_FILE users.
+	UserFile string
+}
+
+// file is the JSON config file. Knobs are keyed by their engine.Config JSON
+// names; durations are strings like "500ms".
+type file struct {
+	Addr           *string                               `json:"addr"`
+	AdminToken     *string                               `json:"adminToken"`
+	SnapshotPath   *string                               `json:"snapshotPath"`
+	TLSCert        *string                               `json:"tlsCert"`
+	TLSKey         *string                               `json:"tlsKey"`
+	DevTLS         *bool                                 `json:"devTls"`
+	AllowedOrigins []string                              `json:"allowedOrigins"`
+	InstanceID     *string                               `json:"instanceId"`
+	PublicURL      *string                               `json:"publicUrl"`
+	HostedRooms    []string                              `json:"hostedRooms"`
+	Backplane      *string                               `json:"backplane"`
+	ServeBackplane *string                               `json:"serveBackplane"`
+	AuthProvider   *string                               `json:"authProvider"`
+	AuthPassword   *string                               `json:"authPassword"`
+	AuthSecret     *string                               `json:"authSecret"`
+	AuthIssuer     *string                               `json:"authIssuer"`
+	AuthUserFile   *string                               `json:"authUserFile"`
+	Game           map[string]json.RawMessage            `json:"game"`
+	Rooms          map[string]map[string]json.RawMessage `json:"rooms"`
+}
+
+type knob struct {
+	name  string
+	flag  string
+	env   string
+	desc  string
+	index []int
+}
+
+type setting struct {
+	name  string
+	value string
+}
+
+// Flags collects the config flags given on the command line, to be applied
+// by Load once files and the environment have been read.
+type Flags struct {
+	path     string
+	settings []setting
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// serverSettings are the settings outside the game knobs, by file name.
+// Their environment variables and flags are named like the knobs'.
+var serverSettings = []string{
+	"addr", "adminToken", "snapshotPath", "tlsCert", "tlsKey", "devTls", "allowedOrigins",
+	"instanceId", "publicUrl", "hostedRooms", "backplane", "serveBackplane",
+	"authProvider", "authPassword", "authSecret", "authIssuer", "authUserFile",
+}
+
+func isServerSetting(name string) bool {
+	for _, setting := range serverSettings {
+		if setting == name {
+			return true
+		}
+	}
+	return false
+}
+
+// setServer parses value into the server setting called name. Lists are
+// comma separated.
+func (c *Config) setServer(name, value string) error {
+	switch name {
+	case "addr":
+		c.Addr = value
+	case "adminToken":
+		c.AdminToken = value
+	case "snapshotPath":
+		c.SnapshotPath = value
+	case "tlsCert":
+		c.TLSCert = value
+	case "tlsKey":
+		c.TLSKey = value
+	case "devTls":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a boolean", name, value)
+		}
+		c.DevTLS = b
+	case "allowedOrigins":
+		c.AllowedOrigins = splitList(value)
+	case "instanceId":
+		c.InstanceID = value
+	case "publicUrl":
+		c.PublicURL = value
+	case "hostedRooms":
+		c.HostedRooms = splitList(value)
+	case "backplane":
+		c.Backplane = value
+	case "serveBackplane":
+		c.ServeBackplane = value
+	case "authProvider":
+		c.Auth.Provider = value
+	case "authPassword":
+		c.Auth.Password = value
+	case "authSecret":
+		c.Auth.Secret = value
+	case "authIssuer":
+		c.Auth.Issuer = value
+	case "authUserFile":
+		c.Auth.UserFile = value
+	default:
+		return fmt.Errorf("unknown setting %q", name)
+	}
+	return nil
+}
+
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// knobs lists the fields of engine.Config that can be configured.
+func knobs() []knob {
+	t := reflect.TypeOf(engine.Config{})
+	list := make([]knob, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		list = append(list, knob{
+			name:  name,
+			flag:  splitWords(name, '-', unicode.ToLower),
+			env:   ENV_PREFIX + splitWords(name, '_', unicode.ToUpper),
+			desc:  field.Tag.Get("desc"),
+			index: field.Index,
+		})
+	}
+	return list
+}
+
+// splitWords turns a camelCase name into words joined by sep.
+func splitWords(name string, sep rune, convert func(rune) rune) string {
+	var b strings.Builder
+	for i, r := range name {
+		if i > 0 && unicode.IsUpper(r) {
+			b.WriteRune(sep)
+		}
+		b.WriteRune(convert(r))
+	}
+	return b.String()
+}
+
+func findKnob(name string) (knob, bool) {
+	for _, k := range knobs() {
+		if k.name == name {
+			return k, true
+		}
+	}
+	return knob{}, false
+}
+
+// set parses value into the knob called name.
+func set(config *engine.Config, name, value string) error {
+	k, ok := findKnob(name)
+	if !ok {
+		return fmt.Errorf("unknown setting %q", name)
+	}
+
+	field := reflect.ValueOf(config).Elem().FieldByIndex(k.index)
+	switch {
+	case field.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		field.SetInt(int64(d))
+	case field.Kind() == reflect.Int || field.Kind() == reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not an integer", name, value)
+		}
+		field.SetInt(n)
+	case field.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a boolean", name, value)
+		}
+		field.SetBool(b)
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+	default:
+		return fmt.Errorf("%s: unsupported type %s", name, field.Type())
+	}
+	return nil
+}
+
+func setAll(config *engine.Config, values map[string]json.RawMessage) error {
+	for name, raw := range values {
+		value := string(raw)
+		if bytes.HasPrefix(raw, []byte(`"`)) {
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+		}
+		if err := set(config, name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func format(config engine.Config, k knob) string {
+	field := reflect.ValueOf(config).FieldByIndex(k.index)
+	if field.Type() == durationType {
+		return time.Duration(field.Int()).String()
+	}
+	return fmt.Sprint(field.Interface())
+}
+
+// RegisterFlags defines -config, one flag per server setting and one per
+// game knob on fs.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{}
+	record := func(name string) func(string) error {
+		return func(value string) error {
+			f.settings = append(f.settings, setting{name, value})
+			return nil
+		}
+	}
+
+	fs.StringVar(&f.path, "config", "", "JSON config file (env "+ENV_PREFIX+"CONFIG)")
+	fs.Func("addr", fmt.Sprintf("address to listen on (env %sADDR, default %s)", ENV_PREFIX, DEFAULT_ADDR), record("addr"))
+	fs.Func("admin-token", "token for the admin endpoints, which are off without one (env "+ENV_PREFIX+"ADMIN_TOKEN)", record("adminToken"))
+	fs.Func("snapshot-path", "file to save the match to on shutdown and resume it from (env "+ENV_PREFIX+"SNAPSHOT_PATH)", record("snapshotPath"))
+	fs.Func("tls-cert", "certificate file to serve HTTPS with, along with -tls-key (env "+ENV_PREFIX+"TLS_CERT)", record("tlsCert"))
+	fs.Func("tls-key", "private key file for -tls-cert (env "+ENV_PREFIX+"TLS_KEY)", record("tlsKey"))
+	fs.BoolFunc("dev-tls", "serve HTTPS with a self-signed certificate for localhost (env "+ENV_PREFIX+"DEV_TLS)", record("devTls"))
+	fs.Func("auth-provider", fmt.Sprintf("who may join: %s, %s, %s, %s or %s (env %sAUTH_PROVIDER, default %s)", AUTH_NONE, AUTH_PASSWORD, AUTH_TOKEN, AUTH_USER_FILE, AUTH_MOCK, ENV_PREFIX, AUTH_NONE), record("authProvider"))
+	fs.Func("auth-password", "shared password for the password provider (env "+ENV_PREFIX+"AUTH_PASSWORD)", record("authPassword"))
+	fs.Func("auth-secret", "HMAC secret the portal signs tokens with, for the token provider (env "+ENV_PREFIX+"AUTH_SECRET)", record("authSecret"))
+	fs.Func("auth-issuer", "issuer tokens must come from, for the token provider (env "+ENV_PREFIX+"AUTH_ISSUER)", record("authIssuer"))
+	fs.Func("auth-user-file", "file of name:hash lines for the userFile provider (env "+ENV_PREFIX+"AUTH_USER_FILE)", record("authUserFile"))
+	fs.Func("allowed-origins", "comma-separated origins, besides this server's, allowed to open WebSocket connections; * allows any (env "+ENV_PREFIX+"ALLOWED_ORIGINS)", record("allowedOrigins"))
+	fs.Func("instance-id", "name of this instance on the backplane (env "+ENV_PREFIX+"INSTANCE_ID, default host-pid)", record("instanceId"))
+	fs.Func("public-url", "URL clients reach this instance at, for redirects from other instances (env "+ENV_PREFIX+"PUBLIC_URL)", record("publicUrl"))
+	fs.Func("hosted-rooms", "comma-separated rooms this instance runs; others are left to other instances (env "+ENV_PREFIX+"HOSTED_ROOMS, default all)", record("hostedRooms"))
+	fs.Func("backplane", fmt.Sprintf("backplane shared with other instances: %s or %s://host:port (env %sBACKPLANE, default %s)", backplane.SCHEME_MEMORY, backplane.SCHEME_REDIS, ENV_PREFIX, DEFAULT_BACKPLANE), record("backplane"))
+	fs.Func("serve-backplane", "address to run a stand-in Redis backplane on for other instances (env "+ENV_PREFIX+"SERVE_BACKPLANE)", record("serveBackplane"))
+	defaults := engine.DefaultConfig()
+	for _, k := range knobs() {
+		usage := fmt.Sprintf("%s (env %s, default %s)", k.desc, k.env, format(defaults, k))
+		if reflect.ValueOf(defaults).FieldByIndex(k.index).Kind() == reflect.Bool {
+			fs.BoolFunc(k.flag, usage, record(k.name))
+		} else {
+			fs.Func(k.flag, usage, record(k.name))
+		}
+	}
+	return f
+}
+
+func readFile(path string) (*file, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f file
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&f); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &f, nil
+}
+
+// Load reads the config file named by -config or ARENA_CONFIG, then the
+// environment, then the recorded flags, and validates every room.
+func (f *Flags) Load(lookupEnv func(string) (string, bool)) (*Config, error) {
+	config := &Config{Addr: DEFAULT_ADDR, Backplane: DEFAULT_BACKPLANE, Auth: Auth{Provider: AUTH_NONE}, Game: engine.DefaultConfig()}
+
+	path := f.path
+	if path == "" {
+		path, _ = lookupEnv(ENV_PREFIX + "CONFIG")
+	}
+	var rooms map[string]map[string]json.RawMessage
+	if path != "" {
+		fileConfig, err := readFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if fileConfig.Addr != nil {
+			config.Addr = *fileConfig.Addr
+		}
+		if fileConfig.AdminToken != nil {
+			config.AdminToken = *fileConfig.AdminToken
+		}
+		if fileConfig.SnapshotPath != nil {
+			config.SnapshotPath = *fileConfig.SnapshotPath
+		}
+		if fileConfig.TLSCert != nil {
+			config.TLSCert = *fileConfig.TLSCert
+		}
+		if fileConfig.TLSKey != nil {
+			config.TLSKey = *fileConfig.TLSKey
+		}
+		if fileConfig.DevTLS != nil {
+			config.DevTLS = *fileConfig.DevTLS
+		}
+		if fileConfig.AllowedOrigins != nil {
+			config.AllowedOrigins = fileConfig.AllowedOrigins
+		}
+		if fileConfig.HostedRooms != nil {
+			config.HostedRooms = fileConfig.HostedRooms
+		}
+		for _, s := range []struct {
+			field *string
+			value *string
+		}{
+			{&config.InstanceID, fileConfig.InstanceID},
+			{&config.PublicURL, fileConfig.PublicURL},
+			{&config.Backplane, fileConfig.Backplane},
+			{&config.ServeBackplane, fileConfig.ServeBackplane},
+			{&config.Auth.Provider, fileConfig.AuthProvider},
+			{&config.Auth.Password, fileConfig.AuthPassword},
+			{&config.Auth.Secret, fileConfig.AuthSecret},
+			{&config.Auth.Issuer, fileConfig.AuthIssuer},
+			{&config.Auth.UserFile, fileConfig.AuthUserFile},
+		} {
+			if s.value != nil {
+				*s.field = *s.value
+			}
+		}
+		if err := setAll(&config.Game, fileConfig.Game); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		rooms = fileConfig.Rooms
+	}
+
+	for _, name := range serverSettings {
+		env := ENV_PREFIX + splitWords(name, '_', unicode.ToUpper)
+		if value, ok := lookupEnv(env); ok {
+			if err := config.setServer(name, value); err != nil {
+				return nil, fmt.Errorf("%s: %v", env, err)
+			}
+		}
+	}
+	for _, k := range knobs() {
+		if value, ok := lookupEnv(k.env); ok {
+			if err := set(&config.Game, k.name, value); err != nil {
+				return nil, fmt.Errorf("%s: %v", k.env, err)
+			}
+		}
+	}
+
+	for _, s := range f.settings {
+		if isServerSetting(s.name) {
+			if err := config.setServer(s.name, s.value); err != nil {
+				return nil, fmt.Errorf("-%s: %v", s.name, err)
+			}
+			continue
+		}
+		if err := set(&config.Game, s.name, s.value); err != nil {
+			return nil, fmt.Errorf("-%s: %v", s.name, err)
+		}
+	}
+
+	config.Rooms = map[string]engine.Config{server.DEFAULT_ROOM: config.Game}
+	for name, overrides := range rooms {
+		if name == "" {
+			return nil, errors.New("rooms need a name")
+		}
+		room := config.Game
+		if err := setAll(&room, overrides); err != nil {
+			return nil, fmt.Errorf("room %q: %v", name, err)
+		}
+		config.Rooms[name] = room
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// ServerChanged reports whether any setting outside the game knobs, which
+// only take effect on restart, differs from old.
+func (c *Config) ServerChanged(old *Config) bool {
+	return c.Addr != old.Addr || c.AdminToken != old.AdminToken || c.SnapshotPath != old.SnapshotPath ||
+		c.TLSCert != old.TLSCert || c.TLSKey != old.TLSKey || c.DevTLS != old.DevTLS ||
+		!slices.Equal(c.AllowedOrigins, old.AllowedOrigins) || c.InstanceID != old.InstanceID ||
+		c.PublicURL != old.PublicURL || !slices.Equal(c.HostedRooms, old.HostedRooms) ||
+		c.Backplane != old.Backplane || c.ServeBackplane != old.ServeBackplane
+}
+
+// Hosted returns the rooms this instance runs.
+func (c *Config) Hosted() map[string]engine.Config {
+	if len(c.HostedRooms) == 0 {
+		return c.Rooms
+	}
+	hosted := make(map[string]engine.Config, len(c.HostedRooms))
+	for _, name := range c.HostedRooms {
+		hosted[name] = c.Rooms[name]
+	}
+	return hosted
+}
+
+func (c *Config) Validate() error {
+	if c.Addr == "" {
+		return errors.New("addr must not be empty")
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return errors.New("tlsCert and tlsKey must be set together")
+	}
+	if c.DevTLS && c.TLSCert != "" {
+		return errors.New("devTls and tlsCert cannot both be set")
+	}
+	if err := c.Auth.validate(); err != nil {
+		return err
+	}
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.Trim(u.Path, "/") != "" {
+			return fmt.Errorf("allowedOrigins: %q is not an origin like https://example.com", origin)
+		}
+	}
+	if c.PublicURL != "" {
+		u, err := url.Parse(c.PublicURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" {
+			return fmt.Errorf("publicUrl: %q is not a URL like https://arena1.example.com", c.PublicURL)
+		}
+	}
+	if u, err := url.Parse(c.Backplane); c.Backplane != backplane.SCHEME_MEMORY &&
+		(err != nil || !(u.Scheme == backplane.SCHEME_MEMORY || u.Scheme == backplane.SCHEME_REDIS && u.Host != "")) {
+		return fmt.Errorf("backplane: %q is not %s or %s://host:port", c.Backplane, backplane.SCHEME_MEMORY, backplane.SCHEME_REDIS)
+	}
+	for _, name := range c.HostedRooms {
+		if _, ok := c.Rooms[name]; !ok {
+			return fmt.Errorf("hostedRooms: no room called %q", name)
+		}
+	}
+	if err := c.Game.Validate(); err != nil {
+		return err
+	}
+	for name, room := range c.Rooms {
+		if err := room.Validate(); err != nil {
+			return fmt.Errorf("room %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func (a Auth) validate() error {
+	switch a.Provider {
+	case AUTH_NONE, AUTH_MOCK:
+	case AUTH_PASSWORD:
+		if a.Password == "" {
+			return errors.New("authPassword is required by the password provider")
+		}
+	case AUTH_TOKEN:
+		if a.Secret == "" {
+			return errors.New("authSecret is required by the token provider")
+		}
+	case AUTH_USER_FILE:
+		if a.UserFile == "" {
+			return errors.New("authUserFile is required by the userFile provider")
+		}
+	default:
+		return fmt.Errorf("authProvider must be %s, %s, %s, %s or %s, got %q", AUTH_NONE, AUTH_PASSWORD, AUTH_TOKEN, AUTH_USER_FILE, AUTH_MOCK, a.Provider)
+	}
+	return nil
+}
+
+// Authenticator builds the configured provider, reading the user file if
+// there is one.
+func (a Auth) Authenticator() (auth.Authenticator, error) {
+	switch a.Provider {
+	case AUTH_PASSWORD:
+		return auth.SharedPassword{Password: a.Password}, nil
+	case AUTH_TOKEN:
+		return auth.HMACToken{Secret: []byte(a.Secret), Issuer: a.Issuer}, nil
+	case AUTH_USER_FILE:
+		return auth.LoadUserFile(a.UserFile)
+	case AUTH_MOCK:
+		return auth.Mock{}, nil
+	}
+	return auth.None{}, nil
+}
@@ -0,0 +1,222 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"multiplayer-game/auth"
+	"multiplayer-game/engine"
+	"multiplayer-game/server"
+)
+
+func writeConfig(t *testing.T, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "arena.json")
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func load(args []string, env map[string]string) (*Config, error) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := RegisterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	return flags.Load(func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	})
+}
+
+func TestDefaults(t *testing.T) {
+	cfg, err := load(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != DEFAULT_ADDR {
+		t.Errorf("addr %q, want %q", cfg.Addr, DEFAULT_ADDR)
+	}
+	if cfg.Game != engine.DefaultConfig() {
+		t.Errorf("game config %+v, want the engine defaults", cfg.Game)
+	}
+	if len(cfg.Rooms) != 1 || cfg.Rooms[server.DEFAULT_ROOM] != cfg.Game {
+		t.Errorf("rooms %+v, want only %q with the game config", cfg.Rooms, server.DEFAULT_ROOM)
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	path := writeConfig(t, `{
+		"addr": ":4000",
+		"snapshotPath": "file.json",
+		"game": {"shootCooldown": "300ms", "respawnTime": "2s", "worldWidth": 200}
+	}`)
+
+	cfg, err := load(
+		[]string{"-config", path, "-world-width", "100", "-fog-of-war", "-snapshot-path", "flag.json"},
+		map[string]string{"ARENA_RESPAWN_TIME": "1s", "ARENA_WORLD_WIDTH": "150", "ARENA_ADDR": ":5000"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Addr != ":5000" {
+		t.Errorf("addr %q: the environment should override the file", cfg.Addr)
+	}
+	if cfg.SnapshotPath != "flag.json" {
+		t.Errorf("snapshotPath %q: flags should override the file", cfg.SnapshotPath)
+	}
+	if cfg.Game.ShootCooldown != 300*time.Millisecond {
+		t.Errorf("shootCooldown %s: the file should override the default", cfg.Game.ShootCooldown)
+	}
+	if cfg.Game.RespawnTime != time.Second {
+		t.Errorf("respawnTime %s: the environment should override the file", cfg.Game.RespawnTime)
+	}
+	if cfg.Game.WorldWidth != 100 {
+		t.Errorf("worldWidth %d: flags should override the environment", cfg.Game.WorldWidth)
+	}
+	if !cfg.Game.FogOfWar {
+		t.Error("fogOfWar flag ignored")
+	}
+}
+
+func TestConfigFromEnvironment(t *testing.T) {
+	path := writeConfig(t, `{"game": {"idleAction": "kick"}}`)
+
+	cfg, err := load(nil, map[string]string{"ARENA_CONFIG": path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Game.IdleAction != engine.IDLE_ACTION_KICK {
+		t.Errorf("idleAction %q, want %q from ARENA_CONFIG", cfg.Game.IdleAction, engine.IDLE_ACTION_KICK)
+	}
+}
+
+func TestRoomOverrides(t *testing.T) {
+	path := writeConfig(t, `{
+		"game": {"shootCooldown": "300ms"},
+		"rooms": {
+			"duel": {"worldWidth": 60, "worldHeight": 30, "fogOfWar": true},
+			"main": {"mapCellsPerWall": 0}
+		}
+	}`)
+
+	cfg, err := load([]string{"-config", path, "-respawn-time", "1s"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	duel := cfg.Rooms["duel"]
+	if duel.WorldWidth != 60 || duel.WorldHeight != 30 || !duel.FogOfWar {
+		t.Errorf("duel overrides not applied: %+v", duel)
+	}
+	if duel.ShootCooldown != 300*time.Millisecond || duel.RespawnTime != time.Second {
+		t.Errorf("duel should inherit the file and flag settings: %+v", duel)
+	}
+	if main := cfg.Rooms[server.DEFAULT_ROOM]; main.MapCellsPerWall != 0 || main.WorldWidth != engine.WORLD_WIDTH {
+		t.Errorf("main room %+v", main)
+	}
+}
+
+func TestServerSettings(t *testing.T) {
+	path := writeConfig(t, `{"allowedOrigins": ["https://file.example"], "tlsCert": "cert.pem", "tlsKey": "key.pem"}`)
+
+	cfg, err := load(
+		[]string{"-config", path},
+		map[string]string{"ARENA_ALLOWED_ORIGINS": "https://a.example, https://b.example,", "ARENA_TLS_KEY": "env.pem"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.AllowedOrigins) != 2 || cfg.AllowedOrigins[0] != "https://a.example" || cfg.AllowedOrigins[1] != "https://b.example" {
+		t.Errorf("allowedOrigins %q: the environment list should replace the file's", cfg.AllowedOrigins)
+	}
+	if cfg.TLSCert != "cert.pem" || cfg.TLSKey != "env.pem" {
+		t.Errorf("tlsCert %q and tlsKey %q", cfg.TLSCert, cfg.TLSKey)
+	}
+
+	cfg, err = load([]string{"-dev-tls", "-auth-provider", "token"}, map[string]string{"ARENA_AUTH_SECRET": "portal"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.DevTLS {
+		t.Error("dev-tls flag ignored")
+	}
+	if cfg.Auth.Provider != AUTH_TOKEN || cfg.Auth.Secret != "portal" {
+		t.Errorf("auth %+v", cfg.Auth)
+	}
+	if a, err := cfg.Auth.Authenticator(); err != nil || a.Method() != auth.METHOD_TOKEN {
+		t.Errorf("authenticator %T (%v), want a token provider", a, err)
+	}
+}
+
+func TestHostedRooms(t *testing.T) {
+	path := writeConfig(t, `{"hostedRooms": ["duel"], "backplane": "redis://bus:6379", "rooms": {"duel": {"worldWidth": 40}, "big": {}}}`)
+
+	cfg, err := load([]string{"-config", path, "-instance-id", "arena-2"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.InstanceID != "arena-2" || cfg.Backplane != "redis://bus:6379" {
+		t.Errorf("instanceId %q and backplane %q", cfg.InstanceID, cfg.Backplane)
+	}
+	if hosted := cfg.Hosted(); len(hosted) != 1 || hosted["duel"].WorldWidth != 40 {
+		t.Errorf("hosted rooms %+v, want only duel", hosted)
+	}
+
+	cfg, err = load([]string{"-config", path}, map[string]string{"ARENA_HOSTED_ROOMS": ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Hosted()) != 3 {
+		t.Errorf("hosted rooms %v, want all of them when the list is empty", cfg.Hosted())
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		args    []string
+		env     map[string]string
+		wantErr string
+	}{
+		{name: "unknown file key", file: `{"gmae": {}}`, wantErr: `unknown field "gmae"`},
+		{name: "unknown knob", file: `{"game": {"wrold": 1}}`, wantErr: `unknown setting "wrold"`},
+		{name: "bad duration", file: `{"game": {"bulletSpeed": "fast"}}`, wantErr: "bulletSpeed"},
+		{name: "bad integer", env: map[string]string{"ARENA_WORLD_WIDTH": "wide"}, wantErr: "ARENA_WORLD_WIDTH"},
+		{name: "out of range flag", args: []string{"-moves-per-tick", "0"}, wantErr: "movesPerTick"},
+		{name: "bad idle action", args: []string{"-idle-action", "ban"}, wantErr: "idleAction"},
+		{name: "invalid room", file: `{"rooms": {"tiny": {"worldWidth": 5}}}`, wantErr: `room "tiny"`},
+		{name: "empty addr", args: []string{"-addr", ""}, wantErr: "addr"},
+		{name: "cert without key", args: []string{"-tls-cert", "cert.pem"}, wantErr: "tlsKey"},
+		{name: "dev and real cert", args: []string{"-tls-cert", "cert.pem", "-tls-key", "key.pem", "-dev-tls"}, wantErr: "devTls"},
+		{name: "bad origin", file: `{"allowedOrigins": ["example.com"]}`, wantErr: "allowedOrigins"},
+		{name: "unknown auth provider", args: []string{"-auth-provider", "oauth"}, wantErr: "authProvider"},
+		{name: "password provider without password", file: `{"authProvider": "password"}`, wantErr: "authPassword"},
+		{name: "bad boolean", env: map[string]string{"ARENA_DEV_TLS": "maybe"}, wantErr: "ARENA_DEV_TLS"},
+		{name: "unknown hosted room", args: []string{"-hosted-rooms", "main,lobby"}, wantErr: `"lobby"`},
+		{name: "bad backplane", env: map[string]string{"ARENA_BACKPLANE": "nats://bus"}, wantErr: "backplane"},
+		{name: "bad public url", args: []string{"-public-url", "arena1:3000"}, wantErr: "publicUrl"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeConfig(t, tt.file)}, args...)
+			}
+
+			_, err := load(args, tt.env)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want one mentioning %s", err, tt.wantErr)
+			}
+		})
+	}
+}
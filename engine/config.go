@@ -0,0 +1,133 @@
+package engine
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Config holds the gameplay knobs of one game. The package constants are
+// the defaults; DefaultConfig returns them.
+type Config struct {
+	WorldWidth      int           `json:"worldWidth" desc:"World width in cells"`
+	WorldHeight     int           `json:"worldHeight" desc:"World height in cells"`
+	BulletSpeed     time.Duration `json:"bulletSpeed" desc:"Time a bullet takes to move one cell"`
+	ShootCooldown   time.Duration `json:"shootCooldown" desc:"Minimum time between two shots"`
+	RespawnTime     time.Duration `json:"respawnTime" desc:"Time a dead player waits before respawning"`
+	SpawnProtection time.Duration `json:"spawnProtection" desc:"Time a respawned player cannot be hit"`
+	TickInterval    time.Duration `json:"tickInterval" desc:"Time between simulation ticks"`
+	MovesPerTick    int           `json:"movesPerTick" desc:"Queued moves applied per player each tick"`
+	MaxQueuedMoves  int           `json:"maxQueuedMoves" desc:"Moves a player can queue ahead of the tick"`
+	MaxRewind       time.Duration `json:"maxRewind" desc:"Largest lag compensation applied to a shot"`
+	IdleTimeout     time.Duration `json:"idleTimeout" desc:"Time without input before a player counts as idle"`
+	IdleAction      string        `json:"idleAction" desc:"What happens to idle players: spectate or kick"`
+	MapSeed         int64         `json:"mapSeed" desc:"Seed the map is generated from"`
+	MapCellsPerWall int           `json:"mapCellsPerWall" desc:"World cells per generated wall; 0 means no walls"`
+	FogOfWar        bool          `json:"fogOfWar" desc:"Only show players what they can see"`
+	VisionRadius    int           `json:"visionRadius" desc:"How far players see under fog of war"`
+	PowerUpInterval time.Duration `json:"powerUpInterval" desc:"Time between power-up spawns; 0 disables power-ups"`
+	MaxPowerUps     int           `json:"maxPowerUps" desc:"Most power-ups on the map at once"`
+	PowerUpDuration time.Duration `json:"powerUpDuration" desc:"How long a power-up's effect lasts"`
+	MagazineSize    int           `json:"magazineSize" desc:"Rounds in a pistol magazine; 0 means unlimited ammo"`
+	SpareAmmo       int           `json:"spareAmmo" desc:"Rounds a player spawns with besides the loaded magazine"`
+	ReloadTime      time.Duration `json:"reloadTime" desc:"Time a pistol takes to reload"`
+	CrateInterval   time.Duration `json:"crateInterval" desc:"Time between weapon and ammo crate spawns; 0 disables crates"`
+	MaxCrates       int           `json:"maxCrates" desc:"Most crates on the map at once"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		WorldWidth:      WORLD_WIDTH,
+		WorldHeight:     WORLD_HEIGHT,
+		BulletSpeed:     BULLET_SPEED,
+		ShootCooldown:   SHOOT_COOLDOWN,
+		RespawnTime:     RESPAWN_TIME,
+		SpawnProtection: SPAWN_PROTECTION,
+		TickInterval:    TICK_INTERVAL,
+		MovesPerTick:    MOVES_PER_TICK,
+		MaxQueuedMoves:  MAX_QUEUED_MOVES,
+		MaxRewind:       MAX_REWIND,
+		IdleTimeout:     IDLE_TIMEOUT,
+		IdleAction:      IDLE_ACTION,
+		MapSeed:         MAP_SEED,
+		MapCellsPerWall: MAP_CELLS_PER_WALL,
+		FogOfWar:        FOG_OF_WAR,
+		VisionRadius:    VISION_RADIUS,
+		PowerUpInterval: POWER_UP_INTERVAL,
+		MaxPowerUps:     MAX_POWER_UPS,
+		PowerUpDuration: POWER_UP_DURATION,
+		MagazineSize:    MAGAZINE_SIZE,
+		SpareAmmo:       SPARE_AMMO,
+		ReloadTime:      RELOAD_TIME,
+		CrateInterval:   CRATE_INTERVAL,
+		MaxCrates:       MAX_CRATES,
+	}
+}
+
+// Validate reports the first knob that is out of range, by its JSON name.
+func (c Config) Validate() error {
+	switch {
+	case c.WorldWidth < MIN_VIEWPORT_WIDTH || c.WorldHeight < MIN_VIEWPORT_HEIGHT:
+		return fmt.Errorf("worldWidth and worldHeight must be at least %dx%d, got %dx%d", MIN_VIEWPORT_WIDTH, MIN_VIEWPORT_HEIGHT, c.WorldWidth, c.WorldHeight)
+	case c.TickInterval <= 0 || c.TickInterval > PLAYER_LIST_INTERVAL:
+		return fmt.Errorf("tickInterval must be between 1ns and %s, got %s", PLAYER_LIST_INTERVAL, c.TickInterval)
+	case c.BulletSpeed <= 0:
+		return fmt.Errorf("bulletSpeed must be positive, got %s", c.BulletSpeed)
+	case c.ShootCooldown < 0:
+		return fmt.Errorf("shootCooldown must not be negative, got %s", c.ShootCooldown)
+	case c.RespawnTime < 0:
+		return fmt.Errorf("respawnTime must not be negative, got %s", c.RespawnTime)
+	case c.SpawnProtection < 0:
+		return fmt.Errorf("spawnProtection must not be negative, got %s", c.SpawnProtection)
+	case c.MovesPerTick < 1:
+		return fmt.Errorf("movesPerTick must be at least 1, got %d", c.MovesPerTick)
+	case c.MaxQueuedMoves < 1:
+		return fmt.Errorf("maxQueuedMoves must be at least 1, got %d", c.MaxQueuedMoves)
+	case c.MaxRewind < 0:
+		return fmt.Errorf("maxRewind must not be negative, got %s", c.MaxRewind)
+	case c.IdleTimeout <= 0:
+		return fmt.Errorf("idleTimeout must be positive, got %s", c.IdleTimeout)
+	case c.IdleAction != IDLE_ACTION_SPECTATE && c.IdleAction != IDLE_ACTION_KICK:
+		return fmt.Errorf("idleAction must be %q or %q, got %q", IDLE_ACTION_SPECTATE, IDLE_ACTION_KICK, c.IdleAction)
+	case c.MapCellsPerWall < 0:
+		return fmt.Errorf("mapCellsPerWall must not be negative, got %d", c.MapCellsPerWall)
+	case c.VisionRadius < 1:
+		return fmt.Errorf("visionRadius must be at least 1, got %d", c.VisionRadius)
+	case c.PowerUpInterval < 0:
+		return fmt.Errorf("powerUpInterval must not be negative, got %s", c.PowerUpInterval)
+	case c.MaxPowerUps < 0:
+		return fmt.Errorf("maxPowerUps must not be negative, got %d", c.MaxPowerUps)
+	case c.PowerUpDuration <= 0:
+		return fmt.Errorf("powerUpDuration must be positive, got %s", c.PowerUpDuration)
+	case c.MagazineSize < 0:
+		return fmt.Errorf("magazineSize must not be negative, got %d", c.MagazineSize)
+	case c.SpareAmmo < 0:
+		return fmt.Errorf("spareAmmo must not be negative, got %d", c.SpareAmmo)
+	case c.ReloadTime < 0:
+		return fmt.Errorf("reloadTime must not be negative, got %s", c.ReloadTime)
+	case c.CrateInterval < 0:
+		return fmt.Errorf("crateInterval must not be negative, got %s", c.CrateInterval)
+	case c.MaxCrates < 0:
+		return fmt.Errorf("maxCrates must not be negative, got %d", c.MaxCrates)
+	}
+	return nil
+}
+
+// Changed returns the JSON names of the knobs that differ from old.
+func (c Config) Changed(old Config) []string {
+	var changed []string
+	now, before := reflect.ValueOf(c), reflect.ValueOf(old)
+	for i := 0; i < now.NumField(); i++ {
+		if now.Field(i).Interface() != before.Field(i).Interface() {
+			changed = append(changed, strings.Split(now.Type().Field(i).Tag.Get("json"), ",")[0])
+		}
+	}
+	return changed
+}
+
+// needsNewWorld reports whether switching from old needs a new map.
+func (c Config) needsNewWorld(old Config) bool {
+	return c.WorldWidth != old.WorldWidth || c.WorldHeight != old.WorldHeight ||
+		c.MapSeed != old.MapSeed || c.MapCellsPerWall != old.MapCellsPerWall
+}
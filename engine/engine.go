@@ -0,0 +1,1003 @@
+// Package engine implements the arena's rules: the world and its map,
+// players, bullets, spawning and the fixed-rate tick. It has no networking;
+// callers feed it player input and read back world state to render.
+package engine
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	WORLD_WIDTH    = 400
+	WORLD_HEIGHT   = 120
+	BULLET_SPEED   = 100 * time.Millisecond
+	SHOOT_COOLDOWN = 500 * time.Millisecond
+	RESPAWN_TIME   = 3 * time.Second
+
+	SPAWN_PROTECTION       = 3 * time.Second
+	SPAWN_CANDIDATES       = 24
+	SPAWN_BULLET_LOOKAHEAD = 20
+	PROTECTION_BLINK_TICKS = 4
+
+	TICK_INTERVAL    = 50 * time.Millisecond
+	MOVES_PER_TICK   = 1
+	MAX_QUEUED_MOVES = 3
+
+	PLAYER_LIST_INTERVAL = time.Second
+	RTT_SMOOTHING        = 0.2
+	MAX_REWIND           = 200 * time.Millisecond
+
+	IDLE_TIMEOUT = 2 * time.Minute
+	IDLE_ACTION  = IDLE_ACTION_SPECTATE
+
+	DEFAULT_VIEWPORT_WIDTH  = 100
+	DEFAULT_VIEWPORT_HEIGHT = 35
+	MIN_VIEWPORT_WIDTH      = 20
+	MIN_VIEWPORT_HEIGHT     = 10
+	MAX_VIEWPORT_WIDTH      = 240
+	MAX_VIEWPORT_HEIGHT     = 80
+	SCROLL_MARGIN           = 10
+
+	MAP_SEED           = 1
+	MAP_CELLS_PER_WALL = 600
+	FOG_OF_WAR         = false
+	VISION_RADIUS      = 20
+
+	POWER_UP_INTERVAL = 10 * time.Second
+	MAX_POWER_UPS     = 12
+	POWER_UP_DURATION = 10 * time.Second
+
+	MAGAZINE_SIZE  = 8
+	SPARE_AMMO     = 24
+	RELOAD_TIME    = 1500 * time.Millisecond
+	CRATE_INTERVAL = 15 * time.Second
+	MAX_CRATES     = 8
+
+	MIN_NAME_LENGTH = 1
+	MAX_NAME_LENGTH = 15
+)
+
+const (
+	IDLE_ACTION_SPECTATE = "spectate"
+	IDLE_ACTION_KICK     = "kick"
+)
+
+// Update flags say which parts of the game state changed in a tick.
+const (
+	UPDATE_WORLD = 1 << iota
+	UPDATE_PLAYER_LIST
+	UPDATE_LEADERBOARD
+)
+
+const (
+	ERR_NOT_JOINED        = "not_joined"
+	ERR_INVALID_NAME      = "invalid_name"
+	ERR_NAME_TAKEN        = "name_taken"
+	ERR_INVALID_CHARACTER = "invalid_character"
+	ERR_CHARACTER_TAKEN   = "character_taken"
+	ERR_INVALID_DIRECTION = "invalid_direction"
+	ERR_COOLDOWN          = "cooldown"
+	ERR_BLOCKED           = "blocked"
+	ERR_DEAD              = "dead"
+	ERR_SPECTATOR         = "spectator"
+	ERR_QUEUE_FULL        = "queue_full"
+	ERR_RECLAIM_FAILED    = "reclaim_failed"
+	ERR_RELOADING         = "reloading"
+	ERR_OUT_OF_AMMO       = "out_of_ammo"
+	ERR_MAGAZINE_FULL     = "magazine_full"
+)
+
+// reservedCharacters are drawn for the border, walls, bullets and items.
+var reservedCharacters = " *|-+#~![^/){}"
+
+type Player struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	X           int       `json:"x"`
+	Y           int       `json:"y"`
+	Character   string    `json:"character"`
+	Kills       int       `json:"kills"`
+	Deaths      int       `json:"deaths"`
+	LastSeen    time.Time `json:"lastSeen"`
+	Dead        bool      `json:"dead"`
+	RespawnAt   time.Time `json:"respawnAt"`
+	LastShot    time.Time `json:"lastShot"`
+	IsSpectator bool      `json:"isSpectator"`
+
+	ProtectedUntil time.Time `json:"protectedUntil"`
+	// Effects holds when each active power-up effect ends.
+	Effects map[string]time.Time `json:"effects"`
+
+	Weapon string `json:"weapon"`
+	// Ammo is the rounds in the magazine and SpareAmmo the rounds carried
+	// besides it. ReloadingUntil is when the reload in progress finishes.
+	Ammo           int       `json:"ammo"`
+	SpareAmmo      int       `json:"spareAmmo"`
+	ReloadingUntil time.Time `json:"reloadingUntil"`
+
+	moveQueue    []queuedMove
+	lastSeq      uint32
+	rtt          time.Duration
+	reclaimToken string
+	// armsChanged is set when the player's weapon or ammo changed since the
+	// last tick reported them.
+	armsChanged bool
+}
+
+type queuedMove struct {
+	direction string
+	seq       uint32
+	ref       interface{}
+}
+
+type Bullet struct {
+	ID        string
+	X         int
+	Y         int
+	DirX      int
+	DirY      int
+	OwnerID   string
+	Character string
+
+	nextStep time.Time
+}
+
+type GameError struct {
+	Code        string `json:"code"`
+	Reason      string `json:"reason"`
+	RequestType string `json:"requestType,omitempty"`
+}
+
+func (e *GameError) Error() string {
+	return e.Reason
+}
+
+type PlayerListEntry struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Character string `json:"character"`
+	Position  string `json:"position" desc:"Position formatted as (x,y)"`
+	Kills     int    `json:"kills"`
+	Deaths    int    `json:"deaths"`
+	Status    string `json:"status" desc:"Alive or Dead with the remaining respawn time"`
+	RTT       int64  `json:"rtt" desc:"Smoothed round-trip time in milliseconds, 0 until measured"`
+	// Effects maps each active power-up to the milliseconds it has left.
+	Effects map[string]int64 `json:"effects,omitempty" desc:"Active power-ups and the milliseconds each has left"`
+}
+
+type LeaderboardEntry struct {
+	Rank      int    `json:"rank"`
+	Name      string `json:"name"`
+	Character string `json:"character"`
+	Kills     int    `json:"kills"`
+	Deaths    int    `json:"deaths"`
+	KDR       string `json:"kdr" desc:"Kill/death ratio with two decimals"`
+}
+
+type EntityState struct {
+	ID        string `json:"id"`
+	Character string `json:"character"`
+	X         int    `json:"x"`
+	Y         int    `json:"y"`
+	Dead      bool   `json:"dead"`
+	Protected bool   `json:"protected,omitempty" desc:"Under spawn protection and cannot be hit"`
+	Invisible bool   `json:"invisible,omitempty" desc:"Hidden from everyone else by a power-up"`
+}
+
+// Game holds the players and the world and applies the rules to them. All
+// methods are safe for concurrent use.
+type Game struct {
+	config       Config
+	pending      *Config
+	players      map[string]*Player
+	world        *GameWorld
+	nextPlayerID uint64
+	tickCount    uint64
+	nextBulletID uint64
+	nextItemID   uint64
+	nextPowerUp  time.Time
+	nextCrate    time.Time
+	history      []historyFrame
+	reclaimable  map[string]*Player
+	reclaimUntil time.Time
+	clock        Clock
+	rng          *rand.Rand
+	mutex        sync.RWMutex
+}
+
+// NewGame creates a game with the given knobs, which must be valid.
+func NewGame(config Config, clock Clock, rng *rand.Rand) *Game {
+	return &Game{
+		config:  config,
+		players: make(map[string]*Player),
+		world:   NewGameWorld(config),
+		clock:   clock,
+		rng:     rng,
+	}
+}
+
+func (g *Game) Config() Config {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	return g.config
+}
+
+// Reconfigure validates config and schedules it for the next tick, returning
+// the names of the knobs that will change. A later call before that tick
+// replaces the pending config.
+func (g *Game) Reconfigure(config Config) ([]string, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	changed := config.Changed(g.config)
+	if len(changed) == 0 {
+		g.pending = nil
+		return nil, nil
+	}
+	g.pending = &config
+	return changed, nil
+}
+
+// applyPending switches to the pending config. Changing the magazine size
+// resizes everyone's magazine. Changing the world's size or map starts a new round: the
+// world is rebuilt, bullets and items are cleared and everyone in play
+// respawns. Scores are kept. Called with the lock held.
+func (g *Game) applyPending(now time.Time) ([]string, bool) {
+	old := g.config
+	g.config = *g.pending
+	g.pending = nil
+
+	if g.config.MagazineSize != old.MagazineSize {
+		for _, player := range g.players {
+			if !player.IsSpectator {
+				g.resizeMagazine(player, old.MagazineSize == 0)
+			}
+		}
+	}
+
+	if !g.config.needsNewWorld(old) {
+		return g.config.Changed(old), false
+	}
+
+	g.world = NewGameWorld(g.config)
+	g.history = nil
+	for _, player := range g.playersByID() {
+		player.moveQueue = nil
+		if !player.Dead && !player.IsSpectator {
+			g.spawn(player, now)
+		}
+	}
+	log.Printf("Started a new round on a %dx%d map (seed %d)", g.world.Width, g.world.Height, g.config.MapSeed)
+	return g.config.Changed(old), true
+}
+
+// World returns the game's current world. Its size, map and walls never
+// change, so they can be read without locking, but a reconfiguration can
+// swap in a new world; render from WorldState.World to stay consistent with
+// a captured tick. Bullets must be read through Capture.
+func (g *Game) World() *GameWorld {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	return g.world
+}
+
+func (g *Game) Now() time.Time {
+	return g.clock.Now()
+}
+
+// WorldState is a copy of everything needed to render the world at one
+// tick, safe to use after the lock is released.
+type WorldState struct {
+	World      *GameWorld
+	Tick       uint64
+	Width      int
+	Height     int
+	Entities   []EntityState
+	Bullets    []Bullet
+	Items      []Item
+	LastSeqs   map[string]uint32
+	SpectatorX int
+	SpectatorY int
+}
+
+func (g *Game) Capture() WorldState {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	return g.captureWorld()
+}
+
+func (g *Game) captureWorld() WorldState {
+	state := WorldState{
+		World:      g.world,
+		Tick:       g.tickCount,
+		Width:      g.world.Width,
+		Height:     g.world.Height,
+		Bullets:    g.world.bulletList(),
+		Items:      g.world.itemList(),
+		LastSeqs:   make(map[string]uint32, len(g.players)),
+		SpectatorX: g.world.Width / 2,
+		SpectatorY: g.world.Height / 2,
+	}
+
+	now := g.clock.Now()
+	players := make([]*Player, 0, len(g.players))
+	var leader *Player
+	for _, player := range g.players {
+		players = append(players, player)
+		state.LastSeqs[player.ID] = player.lastSeq
+
+		if player.Dead || player.IsSpectator || player.hasEffect(POWER_UP_INVISIBILITY, now) {
+			continue
+		}
+		if leader == nil || player.Kills > leader.Kills || (player.Kills == leader.Kills && player.Deaths < leader.Deaths) {
+			leader = player
+		}
+	}
+	state.Entities = buildEntityStates(players, now)
+
+	if leader != nil {
+		state.SpectatorX, state.SpectatorY = leader.X, leader.Y
+	}
+
+	return state
+}
+
+// CameraTarget is the cell a player's camera should follow: the player
+// itself, or the leading visible player for spectators.
+func (state WorldState) CameraTarget(playerID string) (int, int) {
+	for _, entity := range state.Entities {
+		if entity.ID == playerID {
+			return entity.X, entity.Y
+		}
+	}
+	return state.SpectatorX, state.SpectatorY
+}
+
+func validateName(name string) *GameError {
+	length := utf8.RuneCountInString(name)
+	if length < MIN_NAME_LENGTH || length > MAX_NAME_LENGTH {
+		return &GameError{
+			Code:   ERR_INVALID_NAME,
+			Reason: fmt.Sprintf("name must be between %d and %d characters", MIN_NAME_LENGTH, MAX_NAME_LENGTH),
+		}
+	}
+
+	if strings.TrimSpace(name) != name || strings.Contains(name, "  ") {
+		return &GameError{Code: ERR_INVALID_NAME, Reason: "name must not start, end or repeat spaces"}
+	}
+
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune(" _-.", r) {
+			return &GameError{Code: ERR_INVALID_NAME, Reason: "name may only contain letters, digits, spaces and _ - ."}
+		}
+	}
+
+	return nil
+}
+
+func validateCharacter(character string) *GameError {
+	if len(character) != 1 || character[0] < '!' || character[0] > '~' {
+		return &GameError{Code: ERR_INVALID_CHARACTER, Reason: "character must be exactly one printable ASCII symbol"}
+	}
+
+	if strings.Contains(reservedCharacters, character) {
+		return &GameError{Code: ERR_INVALID_CHARACTER, Reason: fmt.Sprintf("character %q is reserved", character)}
+	}
+
+	return nil
+}
+
+// claimed lists the players whose names and characters are in use, those
+// saved for reclaiming after a restart included.
+func (g *Game) claimed() []*Player {
+	taken := make([]*Player, 0, len(g.players)+len(g.reclaimable))
+	for _, p := range g.players {
+		taken = append(taken, p)
+	}
+	if g.clock.Now().Before(g.reclaimUntil) {
+		for _, p := range g.reclaimable {
+			taken = append(taken, p)
+		}
+	}
+	return taken
+}
+
+func (g *Game) validateJoin(name, character string, spectator bool) *GameError {
+	if err := validateName(name); err != nil {
+		return err
+	}
+
+	if !spectator {
+		if err := validateCharacter(character); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range g.claimed() {
+		if strings.EqualFold(p.Name, name) {
+			return &GameError{Code: ERR_NAME_TAKEN, Reason: fmt.Sprintf("name %q is already in use", name)}
+		}
+
+		if !spectator && !p.IsSpectator && p.Character == character {
+			return &GameError{Code: ERR_CHARACTER_TAKEN, Reason: fmt.Sprintf("character %q is already in use", character)}
+		}
+	}
+
+	return nil
+}
+
+// Join adds a player, or a spectator, and spawns it.
+func (g *Game) Join(name, character string, spectator bool) (*Player, *GameError) {
+	name = strings.TrimSpace(name)
+	if spectator {
+		character = ""
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if err := g.validateJoin(name, character, spectator); err != nil {
+		return nil, err
+	}
+
+	g.nextPlayerID++
+	player := &Player{
+		ID:          fmt.Sprintf("p%d", g.nextPlayerID),
+		Name:        name,
+		X:           g.world.Width / 2,
+		Y:           g.world.Height / 2,
+		Character:   character,
+		LastSeen:    g.clock.Now(),
+		IsSpectator: spectator,
+
+		reclaimToken: newReclaimToken(),
+	}
+	if !player.IsSpectator {
+		g.spawn(player, player.LastSeen)
+		g.arm(player)
+	}
+	g.players[player.ID] = player
+
+	return player, nil
+}
+
+// Play puts a spectator, or a player moved to the spectators for being idle,
+// into the game with character. It spawns armed, keeping its name and
+// score. A player already in play is left as it is.
+func (g *Game) Play(playerID, character string) *GameError {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	player, exists := g.players[playerID]
+	if !exists {
+		return &GameError{Code: ERR_NOT_JOINED, Reason: "player is not in the game"}
+	}
+	if !player.IsSpectator {
+		return nil
+	}
+	if err := validateCharacter(character); err != nil {
+		return err
+	}
+	for _, p := range g.claimed() {
+		if !p.IsSpectator && p.Character == character {
+			return &GameError{Code: ERR_CHARACTER_TAKEN, Reason: fmt.Sprintf("character %q is already in use", character)}
+		}
+	}
+
+	now := g.clock.Now()
+	player.IsSpectator = false
+	player.Character = character
+	player.LastSeen = now
+	g.spawn(player, now)
+	g.arm(player)
+	return nil
+}
+
+// Spectating reports whether the player is watching rather than playing.
+func (g *Game) Spectating(playerID string) bool {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	player, exists := g.players[playerID]
+	return exists && player.IsSpectator
+}
+
+// Touch counts as activity for the idle timeout, for what the player does
+// besides moving and shooting, like chatting.
+func (g *Game) Touch(playerID string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if player, exists := g.players[playerID]; exists {
+		player.LastSeen = g.clock.Now()
+	}
+}
+
+func (g *Game) Leave(playerID string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	player, exists := g.players[playerID]
+	if !exists {
+		return
+	}
+	if !player.Dead && !player.IsSpectator {
+		g.world.vacate(player)
+	}
+	delete(g.players, playerID)
+}
+
+func (g *Game) checkCanAct(playerID string) (*Player, *GameError) {
+	player, exists := g.players[playerID]
+	if !exists {
+		return nil, &GameError{Code: ERR_NOT_JOINED, Reason: "player is not in the game"}
+	}
+
+	if player.IsSpectator {
+		return nil, &GameError{Code: ERR_SPECTATOR, Reason: "spectators cannot act"}
+	}
+
+	if player.Dead {
+		return nil, &GameError{Code: ERR_DEAD, Reason: fmt.Sprintf("waiting to respawn (%.1fs)", player.RespawnAt.Sub(g.clock.Now()).Seconds())}
+	}
+
+	return player, nil
+}
+
+// QueueMove queues a move to be applied by a later tick. ref is handed back
+// untouched in that tick's MoveResult so the caller can answer the request.
+func (g *Game) QueueMove(playerID, direction string, seq uint32, ref interface{}) *GameError {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	player, err := g.checkCanAct(playerID)
+	if err != nil {
+		return err
+	}
+
+	if limit := g.config.MaxQueuedMoves * player.speedFactor(g.clock.Now()); len(player.moveQueue) >= limit {
+		return &GameError{Code: ERR_QUEUE_FULL, Reason: fmt.Sprintf("at most %d moves can be queued", limit)}
+	}
+
+	player.moveQueue = append(player.moveQueue, queuedMove{direction: direction, seq: seq, ref: ref})
+	player.LastSeen = g.clock.Now()
+	return nil
+}
+
+// movePlayer moves the player one cell, picking up any power-up there.
+func (g *Game) movePlayer(player *Player, direction string) *GameError {
+	newX, newY := player.X, player.Y
+
+	switch direction {
+	case "up":
+		newY = int(math.Max(0, float64(player.Y-1)))
+	case "down":
+		newY = int(math.Min(float64(g.world.Height-1), float64(player.Y+1)))
+	case "left":
+		newX = int(math.Max(0, float64(player.X-1)))
+	case "right":
+		newX = int(math.Min(float64(g.world.Width-1), float64(player.X+1)))
+	default:
+		return &GameError{Code: ERR_INVALID_DIRECTION, Reason: fmt.Sprintf("unknown direction %q", direction)}
+	}
+
+	if g.world.isWall(newX, newY) {
+		return &GameError{Code: ERR_BLOCKED, Reason: fmt.Sprintf("cell (%d,%d) is a wall", newX, newY)}
+	}
+
+	if p := g.world.occupant(newX, newY); p != nil && p != player {
+		// Bumping into an invisible player must not give it away.
+		if p.hasEffect(POWER_UP_INVISIBILITY, g.clock.Now()) {
+			return &GameError{Code: ERR_BLOCKED, Reason: "cell is occupied"}
+		}
+		return &GameError{Code: ERR_BLOCKED, Reason: fmt.Sprintf("cell (%d,%d) is occupied by %s", newX, newY, p.Name)}
+	}
+
+	g.world.vacate(player)
+	player.X = newX
+	player.Y = newY
+	g.world.place(player)
+	g.pickUp(player, g.clock.Now())
+	return nil
+}
+
+type MoveResult struct {
+	PlayerID string
+	Ref      interface{}
+	Err      *GameError
+}
+
+type TickResult struct {
+	Moves   []MoveResult
+	Idle    []*Player
+	Updates int
+	// Ammo holds the HUD state of the players whose weapon or ammo changed
+	// since the last tick, by player ID.
+	Ammo map[string]AmmoState
+	// Changed lists the knobs a pending config changed this tick, and
+	// NewWorld says whether that started a new round on a new map.
+	Changed  []string
+	NewWorld bool
+}
+
+// Tick advances the game by one tick interval: it applies any pending
+// config and queued moves, steps bullets, respawns players, spawns items,
+// ends effects and reloads and handles idle players.
+func (g *Game) Tick() TickResult {
+	var result TickResult
+	moved := false
+	blinking := false
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := g.clock.Now()
+	g.tickCount++
+	if g.pending != nil {
+		result.Changed, result.NewWorld = g.applyPending(now)
+		result.Updates |= UPDATE_WORLD | UPDATE_PLAYER_LIST
+	}
+	g.recordHistory(now)
+	g.expireReclaims(now)
+	items := len(g.world.items)
+	effectsEnded := false
+	for _, player := range g.playersByID() {
+		if g.expireEffects(player, now) {
+			effectsEnded = true
+		}
+		g.finishReload(player, now)
+		for i := 0; i < g.config.MovesPerTick*player.speedFactor(now) && len(player.moveQueue) > 0; i++ {
+			input := player.moveQueue[0]
+			player.moveQueue = player.moveQueue[1:]
+			if input.seq != 0 {
+				player.lastSeq = input.seq
+			}
+
+			_, err := g.checkCanAct(player.ID)
+			if err == nil {
+				err = g.movePlayer(player, input.direction)
+			}
+			if err == nil {
+				moved = true
+				log.Printf("Player %s moved %s to (%d,%d)", player.Name, input.direction, player.X, player.Y)
+			}
+			result.Moves = append(result.Moves, MoveResult{PlayerID: player.ID, Ref: input.ref, Err: err})
+		}
+
+		// Keep redrawing for one blink after protection ends so the player
+		// doesn't stay hidden.
+		if now.Before(player.ProtectedUntil.Add(PROTECTION_BLINK_TICKS * g.config.TickInterval)) {
+			blinking = true
+		}
+
+		if !player.IsSpectator && now.Sub(player.LastSeen) > g.config.IdleTimeout {
+			result.Idle = append(result.Idle, player)
+			if g.config.IdleAction == IDLE_ACTION_SPECTATE {
+				if !player.Dead {
+					g.world.vacate(player)
+				}
+				player.IsSpectator = true
+				player.Character = ""
+				player.moveQueue = nil
+				moved = true
+			}
+		}
+	}
+	pickedUp := len(g.world.items) < items
+	bulletsMoved, killed := g.stepBullets(now)
+	respawned := g.respawnDue(now)
+	spawned := g.spawnItems(now)
+	result.Ammo = g.armsReport(now)
+
+	idle := len(result.Idle) > 0
+	if moved || bulletsMoved || respawned || idle || spawned || effectsEnded || (blinking && g.tickCount%PROTECTION_BLINK_TICKS == 0) {
+		result.Updates |= UPDATE_WORLD
+	}
+	if killed || respawned || idle || pickedUp || effectsEnded || g.tickCount%uint64(PLAYER_LIST_INTERVAL/g.config.TickInterval) == 0 {
+		result.Updates |= UPDATE_PLAYER_LIST
+	}
+	if killed {
+		result.Updates |= UPDATE_LEADERBOARD
+	}
+
+	return result
+}
+
+// Shoot fires a bullet from the player's position, or three spreading ones
+// with a shotgun or triple shot, and returns the first player hit. Each shot
+// spends a round; an emptied magazine starts reloading. Bullets are advanced
+// through the time the shooter's view lagged behind, so a hit on what the
+// shooter saw counts.
+func (g *Game) Shoot(playerID, direction string) (*Player, *GameError) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	player, err := g.checkCanAct(playerID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := g.clock.Now()
+	if player.reloading(now) {
+		return nil, &GameError{Code: ERR_RELOADING, Reason: fmt.Sprintf("reloading (%dms)", player.ReloadingUntil.Sub(now).Milliseconds())}
+	}
+	if g.config.MagazineSize > 0 && player.Ammo == 0 {
+		// With spare rounds left, an empty magazine that isn't reloading
+		// starts now rather than sending the player after a crate.
+		if err := g.startReload(player, now); err != nil {
+			return nil, err
+		}
+		return nil, &GameError{Code: ERR_RELOADING, Reason: fmt.Sprintf("magazine empty, reloading (%dms)", player.ReloadingUntil.Sub(now).Milliseconds())}
+	}
+	if remaining := g.shootCooldown(player, now) - now.Sub(player.LastShot); remaining > 0 {
+		return nil, &GameError{Code: ERR_COOLDOWN, Reason: fmt.Sprintf("weapon cooling down (%dms)", remaining.Milliseconds())}
+	}
+
+	dirX, dirY := 0, 0
+	switch direction {
+	case "up":
+		dirY = -1
+	case "down":
+		dirY = 1
+	case "left":
+		dirX = -1
+	case "right":
+		dirX = 1
+	default:
+		return nil, &GameError{Code: ERR_INVALID_DIRECTION, Reason: fmt.Sprintf("unknown direction %q", direction)}
+	}
+
+	directions := [][2]int{{dirX, dirY}}
+	if player.weapon().Spread || player.hasEffect(POWER_UP_TRIPLE_SHOT, now) {
+		spreadX, spreadY := abs(dirY), abs(dirX)
+		directions = append(directions, [2]int{dirX - spreadX, dirY - spreadY}, [2]int{dirX + spreadX, dirY + spreadY})
+	}
+	player.LastShot = now
+	player.LastSeen = now
+	player.ProtectedUntil = time.Time{}
+	g.consumeRound(player, now)
+
+	var firstVictim *Player
+	for _, direction := range directions {
+		g.nextBulletID++
+		bullet := &Bullet{
+			ID:        fmt.Sprintf("bullet_%d", g.nextBulletID),
+			X:         player.X,
+			Y:         player.Y,
+			DirX:      direction[0],
+			DirY:      direction[1],
+			OwnerID:   playerID,
+			Character: "*",
+		}
+
+		victim, inWorld := g.fastForwardBullet(bullet, now.Add(-g.rewindFor(player)), now)
+		switch {
+		case victim != nil:
+			g.killPlayer(victim, playerID, now)
+			log.Printf("Player %s hit %s with lag compensation (%dms)", player.Name, victim.Name, g.rewindFor(player).Milliseconds())
+			if firstVictim == nil {
+				firstVictim = victim
+			}
+		case inWorld:
+			g.world.Bullets[bullet.ID] = bullet
+		}
+	}
+
+	return firstVictim, nil
+}
+
+func (g *Game) killPlayer(victim *Player, shooterID string, now time.Time) {
+	g.world.vacate(victim)
+	victim.Dead = true
+	victim.Deaths++
+	victim.RespawnAt = now.Add(g.config.RespawnTime)
+	victim.moveQueue = nil
+	victim.Effects = nil
+	victim.ReloadingUntil = time.Time{}
+
+	if shooter, exists := g.players[shooterID]; exists {
+		shooter.Kills++
+	}
+}
+
+// stepBullets advances every bullet whose next step is due, killing the
+// first player it reaches unless that player is invulnerable. Bullets are
+// processed in ID order so a simulation replays the same way every time.
+func (g *Game) stepBullets(now time.Time) (moved, killed bool) {
+	ids := make([]string, 0, len(g.world.Bullets))
+	for id := range g.world.Bullets {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		bullet := g.world.Bullets[id]
+		for !now.Before(bullet.nextStep) {
+			bullet.nextStep = bullet.nextStep.Add(g.config.BulletSpeed)
+			bullet.X += bullet.DirX
+			bullet.Y += bullet.DirY
+			moved = true
+
+			if g.world.isWall(bullet.X, bullet.Y) {
+				delete(g.world.Bullets, id)
+				break
+			}
+
+			if player := g.world.occupant(bullet.X, bullet.Y); player != nil && player.ID != bullet.OwnerID {
+				delete(g.world.Bullets, id)
+				if !player.invulnerable(now) {
+					g.killPlayer(player, bullet.OwnerID, now)
+					killed = true
+				}
+				break
+			}
+		}
+	}
+
+	return moved, killed
+}
+
+func (g *Game) respawnDue(now time.Time) bool {
+	respawned := false
+	for _, player := range g.playersByID() {
+		if !player.Dead || now.Before(player.RespawnAt) {
+			continue
+		}
+		if player.IsSpectator {
+			player.Dead = false
+		} else {
+			g.spawn(player, now)
+			g.arm(player)
+		}
+		respawned = true
+	}
+	return respawned
+}
+
+func (g *Game) playersByID() []*Player {
+	players := make([]*Player, 0, len(g.players))
+	for _, player := range g.players {
+		players = append(players, player)
+	}
+	sort.Slice(players, func(i, j int) bool {
+		return players[i].ID < players[j].ID
+	})
+	return players
+}
+
+// buildPlayerList lists everyone. Under fog of war, players the viewer
+// cannot see are listed without a position; a nil viewer sees everyone.
+func (g *Game) buildPlayerList(viewer *Player) []PlayerListEntry {
+	now := g.clock.Now()
+	fogged := g.config.FogOfWar && viewer != nil && !viewer.IsSpectator
+	playerList := make([]PlayerListEntry, 0, len(g.players))
+	for _, player := range g.players {
+		status := "Alive"
+		if player.Dead {
+			status = fmt.Sprintf("Dead (%.1fs)", player.RespawnAt.Sub(now).Seconds())
+		} else if player.protected(now) {
+			status = fmt.Sprintf("Protected (%.1fs)", player.ProtectedUntil.Sub(now).Seconds())
+		}
+
+		position := fmt.Sprintf("(%d,%d)", player.X, player.Y)
+		if player.hasEffect(POWER_UP_INVISIBILITY, now) ||
+			fogged && player != viewer && !g.world.CanSee(viewer.X, viewer.Y, player.X, player.Y, g.config.VisionRadius) {
+			position = "(?,?)"
+		}
+
+		var effects map[string]int64
+		for kind, until := range player.Effects {
+			if now.Before(until) {
+				if effects == nil {
+					effects = make(map[string]int64, len(player.Effects))
+				}
+				effects[kind] = until.Sub(now).Milliseconds()
+			}
+		}
+
+		playerList = append(playerList, PlayerListEntry{
+			ID:        player.ID,
+			Name:      player.Name,
+			Character: player.Character,
+			Position:  position,
+			Kills:     player.Kills,
+			Deaths:    player.Deaths,
+			Status:    status,
+			RTT:       player.rtt.Milliseconds(),
+			Effects:   effects,
+		})
+	}
+
+	return playerList
+}
+
+func (g *Game) buildLeaderboard() []LeaderboardEntry {
+	playersSnapshot := make([]*Player, 0, len(g.players))
+	for _, player := range g.players {
+		playersSnapshot = append(playersSnapshot, player)
+	}
+
+	sort.Slice(playersSnapshot, func(i, j int) bool {
+		if playersSnapshot[i].Kills == playersSnapshot[j].Kills {
+			return playersSnapshot[i].Deaths < playersSnapshot[j].Deaths
+		}
+		return playersSnapshot[i].Kills > playersSnapshot[j].Kills
+	})
+
+	leaderboard := make([]LeaderboardEntry, 0, len(playersSnapshot))
+	for i, player := range playersSnapshot {
+		kdr := float64(player.Kills)
+		if player.Deaths > 0 {
+			kdr = float64(player.Kills) / float64(player.Deaths)
+		}
+
+		leaderboard = append(leaderboard, LeaderboardEntry{
+			Rank:      i + 1,
+			Name:      player.Name,
+			Character: player.Character,
+			Kills:     player.Kills,
+			Deaths:    player.Deaths,
+			KDR:       fmt.Sprintf("%.2f", kdr),
+		})
+	}
+
+	return leaderboard
+}
+
+func (g *Game) PlayerList() []PlayerListEntry {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	return g.buildPlayerList(nil)
+}
+
+// PlayerListFor is the player list as the given player may see it, hiding
+// the positions fog of war keeps from it.
+func (g *Game) PlayerListFor(playerID string) []PlayerListEntry {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	return g.buildPlayerList(g.players[playerID])
+}
+
+func (g *Game) Leaderboard() []LeaderboardEntry {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	return g.buildLeaderboard()
+}
+
+func buildEntityStates(players []*Player, now time.Time) []EntityState {
+	states := make([]EntityState, 0, len(players))
+	for _, player := range players {
+		if player.IsSpectator {
+			continue
+		}
+		states = append(states, EntityState{
+			ID:        player.ID,
+			Character: player.Character,
+			X:         player.X,
+			Y:         player.Y,
+			Dead:      player.Dead,
+			Protected: player.protected(now),
+			Invisible: player.hasEffect(POWER_UP_INVISIBILITY, now),
+		})
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].ID < states[j].ID
+	})
+	return states
+}
@@ -0,0 +1,1159 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// newTestGame returns a game on a manual clock with a fixed seed and no
+// walls, so tests control exactly where things are.
+func newTestGame() (*Game, *ManualClock) {
+	clock := NewManualClock(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	config := DefaultConfig()
+	config.MapCellsPerWall = 0
+	return NewGame(config, clock, rand.New(rand.NewSource(1))), clock
+}
+
+func joinTestPlayer(t *testing.T, g *Game, name, character string) *Player {
+	t.Helper()
+
+	player, err := g.Join(name, character, false)
+	if err != nil {
+		t.Fatalf("join %s: %v", name, err)
+	}
+	player.ProtectedUntil = time.Time{}
+	return player
+}
+
+func placePlayer(g *Game, player *Player, x, y int) {
+	g.world.vacate(player)
+	player.X, player.Y = x, y
+	g.world.place(player)
+}
+
+// advance steps the clock tick by tick, running the game tick each time.
+func advance(g *Game, clock *ManualClock, d time.Duration) {
+	for elapsed := time.Duration(0); elapsed < d; elapsed += TICK_INTERVAL {
+		clock.Advance(TICK_INTERVAL)
+		g.Tick()
+	}
+}
+
+func shootCode(_ *Player, err *GameError) string {
+	return errorCode(err)
+}
+
+func errorCode(err *GameError) string {
+	if err == nil {
+		return ""
+	}
+	return err.Code
+}
+
+func TestMovePlayer(t *testing.T) {
+	tests := []struct {
+		name      string
+		x, y      int
+		direction string
+		blocker   *[2]int
+		wall      *[2]int
+		wantX     int
+		wantY     int
+		wantCode  string
+	}{
+		{name: "up", x: 50, y: 50, direction: "up", wantX: 50, wantY: 49},
+		{name: "down", x: 50, y: 50, direction: "down", wantX: 50, wantY: 51},
+		{name: "left", x: 50, y: 50, direction: "left", wantX: 49, wantY: 50},
+		{name: "right", x: 50, y: 50, direction: "right", wantX: 51, wantY: 50},
+		{name: "top edge", x: 50, y: 0, direction: "up", wantX: 50, wantY: 0},
+		{name: "bottom edge", x: 50, y: WORLD_HEIGHT - 1, direction: "down", wantX: 50, wantY: WORLD_HEIGHT - 1},
+		{name: "left edge", x: 0, y: 50, direction: "left", wantX: 0, wantY: 50},
+		{name: "right edge", x: WORLD_WIDTH - 1, y: 50, direction: "right", wantX: WORLD_WIDTH - 1, wantY: 50},
+		{name: "occupied", x: 50, y: 50, direction: "right", blocker: &[2]int{51, 50}, wantX: 50, wantY: 50, wantCode: ERR_BLOCKED},
+		{name: "wall", x: 50, y: 50, direction: "down", wall: &[2]int{50, 51}, wantX: 50, wantY: 50, wantCode: ERR_BLOCKED},
+		{name: "unknown direction", x: 50, y: 50, direction: "sideways", wantX: 50, wantY: 50, wantCode: ERR_INVALID_DIRECTION},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, _ := newTestGame()
+			player := joinTestPlayer(t, g, "mover", "M")
+			placePlayer(g, player, tt.x, tt.y)
+
+			if tt.blocker != nil {
+				blocker := joinTestPlayer(t, g, "blocker", "B")
+				placePlayer(g, blocker, tt.blocker[0], tt.blocker[1])
+			}
+			if tt.wall != nil {
+				g.world.walls[tt.wall[1]*g.world.Width+tt.wall[0]] = true
+			}
+
+			err := g.movePlayer(player, tt.direction)
+			if got := errorCode(err); got != tt.wantCode {
+				t.Fatalf("error code = %q, want %q", got, tt.wantCode)
+			}
+			if player.X != tt.wantX || player.Y != tt.wantY {
+				t.Fatalf("position = (%d,%d), want (%d,%d)", player.X, player.Y, tt.wantX, tt.wantY)
+			}
+			if g.world.occupant(player.X, player.Y) != player {
+				t.Fatalf("occupancy index does not have the player at (%d,%d)", player.X, player.Y)
+			}
+		})
+	}
+}
+
+func TestMoveSequenceEcho(t *testing.T) {
+	g, clock := newTestGame()
+	player := joinTestPlayer(t, g, "player", "P")
+	other := joinTestPlayer(t, g, "other", "O")
+	placePlayer(g, player, 10, 10)
+	placePlayer(g, other, 12, 10)
+
+	steps := []struct {
+		seqs    []uint32
+		lastSeq uint32
+		x       int
+		err     string
+	}{
+		{[]uint32{1}, 1, 11, ""},
+		// Rejected moves are applied too, so they are echoed.
+		{[]uint32{2}, 2, 11, ERR_BLOCKED},
+		// Only MOVES_PER_TICK moves are applied per tick.
+		{[]uint32{3, 4}, 3, 11, ERR_BLOCKED},
+		{nil, 4, 11, ERR_BLOCKED},
+		// Moves without a seq leave lastSeq alone.
+		{[]uint32{0}, 4, 11, ERR_BLOCKED},
+	}
+	for i, step := range steps {
+		for _, seq := range step.seqs {
+			if err := g.QueueMove(player.ID, "right", seq, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		clock.Advance(TICK_INTERVAL)
+		result := g.Tick()
+		if lastSeq := g.Capture().LastSeqs[player.ID]; lastSeq != step.lastSeq || player.X != step.x {
+			t.Fatalf("step %d: lastSeq %d at x=%d, want %d at x=%d", i, lastSeq, player.X, step.lastSeq, step.x)
+		}
+		if len(result.Moves) != 1 || errorCode(result.Moves[0].Err) != step.err {
+			t.Fatalf("step %d: move results %+v, want one with %q", i, result.Moves, step.err)
+		}
+	}
+}
+
+func TestMoveIgnoresDeadPlayers(t *testing.T) {
+	g, clock := newTestGame()
+	player := joinTestPlayer(t, g, "mover", "M")
+	corpse := joinTestPlayer(t, g, "corpse", "C")
+	placePlayer(g, player, 50, 50)
+	placePlayer(g, corpse, 51, 50)
+	g.killPlayer(corpse, player.ID, clock.Now())
+
+	if err := g.movePlayer(player, "right"); err != nil {
+		t.Fatalf("moving onto a dead player: %v", err)
+	}
+	if player.X != 51 {
+		t.Fatalf("x = %d, want 51", player.X)
+	}
+}
+
+func TestClampViewportSize(t *testing.T) {
+	tests := []struct {
+		width, height int
+		wantW, wantH  int
+	}{
+		{0, 0, DEFAULT_VIEWPORT_WIDTH, DEFAULT_VIEWPORT_HEIGHT},
+		{-1, 50, DEFAULT_VIEWPORT_WIDTH, DEFAULT_VIEWPORT_HEIGHT},
+		{10, 5, MIN_VIEWPORT_WIDTH, MIN_VIEWPORT_HEIGHT},
+		{500, 200, MAX_VIEWPORT_WIDTH, MAX_VIEWPORT_HEIGHT},
+		{120, 40, 120, 40},
+	}
+	for _, tt := range tests {
+		if w, h := ClampViewportSize(tt.width, tt.height); w != tt.wantW || h != tt.wantH {
+			t.Errorf("ClampViewportSize(%d, %d) = %dx%d, want %dx%d", tt.width, tt.height, w, h, tt.wantW, tt.wantH)
+		}
+	}
+}
+
+func TestFollowCamera(t *testing.T) {
+	// A 100x35 view scrolls within 10 cells of its sides and, being a
+	// quarter of its height, 8 cells of its top and bottom.
+	camera := Viewport{X: 150, Y: 43, Width: 100, Height: 35}
+	tests := []struct {
+		name                    string
+		camera                  Viewport
+		targetX, targetY        int
+		worldWidth, worldHeight int
+		want                    Viewport
+	}{
+		{"new camera centered", Viewport{}, 200, 60, 400, 120, camera},
+		{"small move", camera, 205, 55, 400, 120, camera},
+		{"at the right margin", camera, 239, 60, 400, 120, camera},
+		{"past the right margin", camera, 240, 60, 400, 120, Viewport{X: 151, Y: 43, Width: 100, Height: 35}},
+		{"past the left margin", camera, 159, 60, 400, 120, Viewport{X: 149, Y: 43, Width: 100, Height: 35}},
+		{"past the bottom margin", camera, 200, 70, 400, 120, Viewport{X: 150, Y: 44, Width: 100, Height: 35}},
+		{"clamped at the left border", Viewport{X: 0, Y: 43, Width: 100, Height: 35}, 3, 60, 400, 120, Viewport{X: 0, Y: 43, Width: 100, Height: 35}},
+		{"clamped at the right and bottom borders", Viewport{}, 399, 119, 400, 120, Viewport{X: 300, Y: 85, Width: 100, Height: 35}},
+		{"world smaller than the view", Viewport{}, 25, 10, 50, 20, Viewport{X: 0, Y: 0, Width: 50, Height: 20}},
+	}
+	for _, tt := range tests {
+		got := FollowCamera(tt.camera, 100, 35, tt.targetX, tt.targetY, tt.worldWidth, tt.worldHeight)
+		if got != tt.want {
+			t.Errorf("%s: camera %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestShootDirections(t *testing.T) {
+	tests := []struct {
+		direction  string
+		wantDirX   int
+		wantDirY   int
+		wantCode   string
+		wantBullet bool
+	}{
+		{direction: "up", wantDirY: -1, wantBullet: true},
+		{direction: "down", wantDirY: 1, wantBullet: true},
+		{direction: "left", wantDirX: -1, wantBullet: true},
+		{direction: "right", wantDirX: 1, wantBullet: true},
+		{direction: "diagonal", wantCode: ERR_INVALID_DIRECTION},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.direction, func(t *testing.T) {
+			g, _ := newTestGame()
+			player := joinTestPlayer(t, g, "shooter", "S")
+			placePlayer(g, player, 50, 50)
+
+			_, err := g.Shoot(player.ID, tt.direction)
+			if got := errorCode(err); got != tt.wantCode {
+				t.Fatalf("error code = %q, want %q", got, tt.wantCode)
+			}
+
+			if (len(g.world.Bullets) > 0) != tt.wantBullet {
+				t.Fatalf("bullets = %d, want bullet: %v", len(g.world.Bullets), tt.wantBullet)
+			}
+			for _, bullet := range g.world.Bullets {
+				if bullet.DirX != tt.wantDirX || bullet.DirY != tt.wantDirY || bullet.OwnerID != player.ID {
+					t.Fatalf("bullet = %+v, want direction (%d,%d) owned by %s", bullet, tt.wantDirX, tt.wantDirY, player.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestShootCooldown(t *testing.T) {
+	g, clock := newTestGame()
+	player := joinTestPlayer(t, g, "shooter", "S")
+
+	steps := []struct {
+		wait     time.Duration
+		wantCode string
+	}{
+		{wait: 0, wantCode: ""},
+		{wait: 0, wantCode: ERR_COOLDOWN},
+		{wait: SHOOT_COOLDOWN - time.Millisecond, wantCode: ERR_COOLDOWN},
+		{wait: time.Millisecond, wantCode: ""},
+	}
+
+	for i, step := range steps {
+		clock.Advance(step.wait)
+		if got := shootCode(g.Shoot(player.ID, "up")); got != step.wantCode {
+			t.Fatalf("shot %d: error code = %q, want %q", i, got, step.wantCode)
+		}
+	}
+}
+
+func TestCustomConfig(t *testing.T) {
+	config := DefaultConfig()
+	config.WorldWidth, config.WorldHeight = 30, 12
+	config.ShootCooldown = 2 * time.Second
+	config.MapCellsPerWall = 0
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	clock := NewManualClock(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	g := NewGame(config, clock, rand.New(rand.NewSource(1)))
+	player := joinTestPlayer(t, g, "small", "S")
+
+	if player.X >= 30 || player.Y >= 12 {
+		t.Fatalf("spawned at (%d,%d) outside a 30x12 world", player.X, player.Y)
+	}
+	placePlayer(g, player, 29, 11)
+	g.movePlayer(player, "right")
+	g.movePlayer(player, "down")
+	if player.X != 29 || player.Y != 11 {
+		t.Fatalf("moved to (%d,%d), past the corner of a 30x12 world", player.X, player.Y)
+	}
+
+	g.Shoot(player.ID, "up")
+	clock.Advance(SHOOT_COOLDOWN)
+	if got := shootCode(g.Shoot(player.ID, "up")); got != ERR_COOLDOWN {
+		t.Fatalf("error code = %q, want %q with a 2s cooldown", got, ERR_COOLDOWN)
+	}
+}
+
+func TestReconfigure(t *testing.T) {
+	g, clock := newTestGame()
+	player := joinTestPlayer(t, g, "shooter", "S")
+
+	config := g.Config()
+	config.ShootCooldown = 2 * time.Second
+	config.RespawnTime = time.Second
+	changed, err := g.Reconfigure(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(changed, ",") != "shootCooldown,respawnTime" {
+		t.Fatalf("changed = %v", changed)
+	}
+	if g.Config().ShootCooldown != SHOOT_COOLDOWN {
+		t.Fatal("config applied before the next tick")
+	}
+
+	clock.Advance(TICK_INTERVAL)
+	result := g.Tick()
+	if len(result.Changed) != 2 || result.NewWorld {
+		t.Fatalf("tick result changed %v, new world %v", result.Changed, result.NewWorld)
+	}
+
+	g.Shoot(player.ID, "up")
+	clock.Advance(SHOOT_COOLDOWN)
+	if got := shootCode(g.Shoot(player.ID, "up")); got != ERR_COOLDOWN {
+		t.Fatalf("error code = %q, want %q after raising the cooldown", got, ERR_COOLDOWN)
+	}
+
+	if _, err := g.Reconfigure(config); err != nil {
+		t.Fatal(err)
+	}
+	if result := g.Tick(); len(result.Changed) != 0 {
+		t.Fatalf("reapplying the same config changed %v", result.Changed)
+	}
+
+	config.MovesPerTick = 0
+	if _, err := g.Reconfigure(config); err == nil {
+		t.Fatal("invalid config accepted")
+	}
+}
+
+func TestReconfigureStartsNewRound(t *testing.T) {
+	g, clock := newTestGame()
+	alive := joinTestPlayer(t, g, "alive", "A")
+	dead := joinTestPlayer(t, g, "dead", "D")
+	placePlayer(g, alive, 300, 100)
+	alive.Kills = 2
+	g.Shoot(alive.ID, "up")
+	g.killPlayer(dead, alive.ID, clock.Now())
+
+	config := g.Config()
+	config.WorldWidth, config.WorldHeight = 40, 20
+	if _, err := g.Reconfigure(config); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(TICK_INTERVAL)
+	if result := g.Tick(); !result.NewWorld {
+		t.Fatal("resizing the world did not start a new round")
+	}
+
+	state := g.Capture()
+	if state.Width != 40 || state.Height != 20 || len(state.Bullets) != 0 {
+		t.Fatalf("world %dx%d with %d bullets after the new round", state.Width, state.Height, len(state.Bullets))
+	}
+	if alive.X >= 40 || alive.Y >= 20 || g.world.occupant(alive.X, alive.Y) != alive {
+		t.Fatalf("alive player at (%d,%d) was not respawned in the new world", alive.X, alive.Y)
+	}
+	if alive.Kills != 3 {
+		t.Fatalf("kills = %d, scores should survive a new round", alive.Kills)
+	}
+
+	advance(g, clock, RESPAWN_TIME)
+	if dead.Dead || dead.X >= 40 || dead.Y >= 20 {
+		t.Fatalf("dead player respawned at (%d,%d), dead=%v", dead.X, dead.Y, dead.Dead)
+	}
+}
+
+// restoredGame snapshots g, round-trips it through JSON and restores it
+// into a fresh game an hour later on its own clock.
+func restoredGame(t *testing.T, g *Game) (*Game, *ManualClock) {
+	t.Helper()
+
+	data, err := json.Marshal(g.Snapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, clock := newTestGame()
+	clock.Advance(time.Hour)
+	restored.Restore(snapshot)
+	return restored, clock
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	g, _ := newTestGame()
+	shooter := joinTestPlayer(t, g, "shooter", "S")
+	placePlayer(g, shooter, 10, 10)
+	shooter.Kills, shooter.Deaths = 4, 1
+	if _, err := g.Shoot(shooter.ID, "right"); err != nil {
+		t.Fatal(err)
+	}
+	g.Tick()
+
+	restored, clock := restoredGame(t, g)
+	if state := restored.Capture(); len(state.Entities) != 0 || len(state.Bullets) != 1 {
+		t.Fatalf("restored %d players and %d bullets, want only the bullet until players reclaim", len(state.Entities), len(state.Bullets))
+	}
+	if restored.tickCount != g.tickCount {
+		t.Fatalf("tick %d, want the saved match clock %d", restored.tickCount, g.tickCount)
+	}
+	if _, err := restored.Join("shooter", "X", false); errorCode(err) != ERR_NAME_TAKEN {
+		t.Fatalf("joining with a saved name: got %q, want %q", errorCode(err), ERR_NAME_TAKEN)
+	}
+
+	player, err := restored.Reclaim(shooter.ReclaimToken())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if player.ID != shooter.ID || player.X != 10 || player.Y != 10 || player.Kills != 4 || player.Deaths != 1 {
+		t.Fatalf("reclaimed %+v, want the saved player", player)
+	}
+	if restored.world.occupant(10, 10) != player {
+		t.Fatal("reclaimed player is not on the grid")
+	}
+	if code := shootCode(restored.Shoot(player.ID, "right")); code != ERR_COOLDOWN {
+		t.Fatalf("shooting right after a restore: got %q, want the saved cooldown", code)
+	}
+	if _, err := restored.Reclaim(shooter.ReclaimToken()); errorCode(err) != ERR_RECLAIM_FAILED {
+		t.Fatalf("reclaiming twice: got %q, want %q", errorCode(err), ERR_RECLAIM_FAILED)
+	}
+
+	next, _ := restored.Join("next", "N", false)
+	if next.ID == shooter.ID {
+		t.Fatalf("new player reused saved ID %s", next.ID)
+	}
+	advance(restored, clock, BULLET_SPEED)
+	if bullets := restored.Capture().Bullets; len(bullets) != 1 || bullets[0].X != 11 {
+		t.Fatalf("bullets %+v did not keep flying after the restore", bullets)
+	}
+}
+
+func TestReclaimExpires(t *testing.T) {
+	g, _ := newTestGame()
+	player := joinTestPlayer(t, g, "gone", "G")
+
+	restored, clock := restoredGame(t, g)
+	clock.Advance(RECLAIM_WINDOW + time.Second)
+	restored.Tick()
+
+	if _, err := restored.Reclaim(player.ReclaimToken()); errorCode(err) != ERR_RECLAIM_FAILED {
+		t.Fatalf("late reclaim: got %q, want %q", errorCode(err), ERR_RECLAIM_FAILED)
+	}
+	if _, err := restored.Join("gone", "G", false); err != nil {
+		t.Fatalf("saved name still reserved after the reclaim window: %v", err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+	}{
+		{"tiny world", func(c *Config) { c.WorldWidth = 5 }},
+		{"zero tick", func(c *Config) { c.TickInterval = 0 }},
+		{"tick slower than player list", func(c *Config) { c.TickInterval = 2 * PLAYER_LIST_INTERVAL }},
+		{"zero bullet speed", func(c *Config) { c.BulletSpeed = 0 }},
+		{"negative cooldown", func(c *Config) { c.ShootCooldown = -time.Second }},
+		{"no moves per tick", func(c *Config) { c.MovesPerTick = 0 }},
+		{"unknown idle action", func(c *Config) { c.IdleAction = "ban" }},
+		{"negative walls", func(c *Config) { c.MapCellsPerWall = -1 }},
+		{"blind", func(c *Config) { c.VisionRadius = 0 }},
+		{"negative power-ups", func(c *Config) { c.MaxPowerUps = -1 }},
+		{"instant power-ups", func(c *Config) { c.PowerUpDuration = 0 }},
+		{"negative magazine", func(c *Config) { c.MagazineSize = -1 }},
+		{"negative reload", func(c *Config) { c.ReloadTime = -time.Second }},
+		{"negative crates", func(c *Config) { c.MaxCrates = -1 }},
+	}
+
+	if err := DefaultConfig().Validate(); err != nil {
+		t.Fatalf("defaults: %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			tt.modify(&config)
+			if config.Validate() == nil {
+				t.Fatalf("%+v passed validation", config)
+			}
+		})
+	}
+}
+
+func TestShootRejectsInactivePlayers(t *testing.T) {
+	g, clock := newTestGame()
+	player := joinTestPlayer(t, g, "shooter", "S")
+	g.killPlayer(player, "", clock.Now())
+
+	if got := shootCode(g.Shoot(player.ID, "up")); got != ERR_DEAD {
+		t.Fatalf("dead player: error code = %q, want %q", got, ERR_DEAD)
+	}
+	if got := shootCode(g.Shoot("missing", "up")); got != ERR_NOT_JOINED {
+		t.Fatalf("unknown player: error code = %q, want %q", got, ERR_NOT_JOINED)
+	}
+}
+
+func TestIdlePlayersSpectateAndRejoin(t *testing.T) {
+	g, clock := newTestGame()
+	chatter := joinTestPlayer(t, g, "chatter", "C")
+	idler := joinTestPlayer(t, g, "idler", "I")
+
+	clock.Advance(IDLE_TIMEOUT / 2)
+	g.Touch(chatter.ID)
+	clock.Advance(IDLE_TIMEOUT/2 + time.Second)
+	result := g.Tick()
+	if len(result.Idle) != 1 || result.Idle[0] != idler || !g.Spectating(idler.ID) || g.Spectating(chatter.ID) {
+		t.Fatalf("idle %v; only the idler should have become a spectator", result.Idle)
+	}
+
+	for character, code := range map[string]string{"C": ERR_CHARACTER_TAKEN, "#": ERR_INVALID_CHARACTER} {
+		if got := errorCode(g.Play(idler.ID, character)); got != code {
+			t.Errorf("playing as %q: %q, want %q", character, got, code)
+		}
+	}
+	if err := g.Play(idler.ID, "J"); err != nil {
+		t.Fatal(err)
+	}
+	if g.Spectating(idler.ID) || idler.Character != "J" || idler.Dead || g.world.occupant(idler.X, idler.Y) != idler || idler.Ammo != MAGAZINE_SIZE {
+		t.Fatalf("after playing again: %+v", idler)
+	}
+	if err := g.QueueMove(idler.ID, "up", 0, nil); err != nil {
+		t.Fatalf("moving after playing again: %v", err)
+	}
+
+	if err := g.Play(chatter.ID, "Z"); err != nil || chatter.Character != "C" {
+		t.Fatalf("playing while in play: %v, character %q", err, chatter.Character)
+	}
+}
+
+func TestBulletKillAttribution(t *testing.T) {
+	tests := []struct {
+		name       string
+		protected  bool
+		wantKilled bool
+	}{
+		{name: "unprotected", wantKilled: true},
+		{name: "spawn protected", protected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, clock := newTestGame()
+			shooter := joinTestPlayer(t, g, "shooter", "S")
+			victim := joinTestPlayer(t, g, "victim", "V")
+			bystander := joinTestPlayer(t, g, "bystander", "B")
+			placePlayer(g, shooter, 100, 60)
+			placePlayer(g, victim, 105, 60)
+			placePlayer(g, bystander, 100, 62)
+			if tt.protected {
+				victim.ProtectedUntil = clock.Now().Add(time.Minute)
+			}
+
+			if _, err := g.Shoot(shooter.ID, "right"); err != nil {
+				t.Fatalf("shoot: %v", err)
+			}
+			advance(g, clock, 5*BULLET_SPEED)
+
+			if len(g.world.Bullets) != 0 {
+				t.Fatalf("bullet still flying after reaching the victim")
+			}
+			if victim.Dead != tt.wantKilled {
+				t.Fatalf("victim dead = %v, want %v", victim.Dead, tt.wantKilled)
+			}
+
+			wantKills := 0
+			if tt.wantKilled {
+				wantKills = 1
+			}
+			if shooter.Kills != wantKills || victim.Deaths != wantKills {
+				t.Fatalf("shooter kills = %d, victim deaths = %d, want %d", shooter.Kills, victim.Deaths, wantKills)
+			}
+			if bystander.Kills != 0 || bystander.Deaths != 0 || shooter.Deaths != 0 {
+				t.Fatalf("kill credited to the wrong player: bystander %d/%d, shooter deaths %d", bystander.Kills, bystander.Deaths, shooter.Deaths)
+			}
+		})
+	}
+}
+
+func TestBulletStopsAtWall(t *testing.T) {
+	g, clock := newTestGame()
+	shooter := joinTestPlayer(t, g, "shooter", "S")
+	victim := joinTestPlayer(t, g, "victim", "V")
+	placePlayer(g, shooter, 100, 60)
+	placePlayer(g, victim, 105, 60)
+	g.world.walls[60*g.world.Width+103] = true
+
+	g.Shoot(shooter.ID, "right")
+	advance(g, clock, 5*BULLET_SPEED)
+
+	if victim.Dead || len(g.world.Bullets) != 0 {
+		t.Fatalf("victim dead = %v, bullets = %d; the wall should have stopped the bullet", victim.Dead, len(g.world.Bullets))
+	}
+}
+
+func TestFogHidesPlayerListPositions(t *testing.T) {
+	g, _ := newTestGame()
+	g.config.FogOfWar = true
+	viewer := joinTestPlayer(t, g, "viewer", "V")
+	near := joinTestPlayer(t, g, "near", "N")
+	far := joinTestPlayer(t, g, "far", "F")
+	walled := joinTestPlayer(t, g, "walled", "W")
+	spectator, _ := g.Join("spectator", "S", true)
+	placePlayer(g, viewer, 100, 60)
+	placePlayer(g, near, 110, 60)
+	placePlayer(g, far, 100, 60+VISION_RADIUS+1)
+	placePlayer(g, walled, 90, 60)
+	g.world.walls[60*g.world.Width+95] = true
+
+	positions := func(list []PlayerListEntry) map[string]string {
+		byName := make(map[string]string, len(list))
+		for _, entry := range list {
+			byName[entry.Name] = entry.Position
+		}
+		return byName
+	}
+
+	want := map[string]string{"viewer": "(100,60)", "near": "(110,60)", "far": "(?,?)", "walled": "(?,?)"}
+	seen := positions(g.PlayerListFor(viewer.ID))
+	for name, position := range want {
+		if seen[name] != position {
+			t.Errorf("viewer sees %s at %s, want %s", name, seen[name], position)
+		}
+	}
+	for name, position := range positions(g.PlayerListFor(spectator.ID)) {
+		if position == "(?,?)" {
+			t.Errorf("spectator should see %s", name)
+		}
+	}
+}
+
+func TestLagCompensation(t *testing.T) {
+	tests := []struct {
+		name      string
+		rtt       time.Duration
+		sinceMove time.Duration
+		protected bool
+		hit       bool
+	}{
+		// Bullets step every 50ms, so a shot hits the old position when
+		// the shooter's view is at least sinceMove-50ms behind.
+		{"no lag", 0, 100 * time.Millisecond, false, false},
+		{"rewound by half the rtt", 300 * time.Millisecond, 100 * time.Millisecond, false, true},
+		{"not rewound by the full rtt", 200 * time.Millisecond, 100 * time.Millisecond, false, false},
+		{"rewind clamped to maxRewind", time.Second, 250 * time.Millisecond, false, false},
+		{"rewound onto an invulnerable victim", 300 * time.Millisecond, 100 * time.Millisecond, true, false},
+	}
+	for _, tt := range tests {
+		g, clock := newTestGame()
+		g.config.BulletSpeed = 50 * time.Millisecond
+		shooter := joinTestPlayer(t, g, "shooter", "S")
+		victim := joinTestPlayer(t, g, "victim", "V")
+		placePlayer(g, shooter, 100, 60)
+		placePlayer(g, victim, 101, 60)
+		advance(g, clock, 500*time.Millisecond)
+
+		// The victim steps out of the line of fire before the shooter's
+		// view catches up.
+		placePlayer(g, victim, 101, 62)
+		advance(g, clock, tt.sinceMove)
+		if tt.protected {
+			victim.ProtectedUntil = clock.Now().Add(time.Second)
+		}
+		if tt.rtt > 0 {
+			g.RecordRTT(shooter.ID, tt.rtt)
+		}
+
+		hit, err := g.Shoot(shooter.ID, "right")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if (hit == victim) != tt.hit || victim.Dead != tt.hit {
+			t.Errorf("%s: hit = %v, victim dead = %v, want hit = %v", tt.name, hit == victim, victim.Dead, tt.hit)
+		}
+		if tt.protected && len(g.world.Bullets) != 0 {
+			t.Errorf("%s: %d bullets left, the invulnerable victim should have absorbed the shot", tt.name, len(g.world.Bullets))
+		}
+	}
+}
+
+func TestRespawn(t *testing.T) {
+	g, clock := newTestGame()
+	shooter := joinTestPlayer(t, g, "shooter", "S")
+	victim := joinTestPlayer(t, g, "victim", "V")
+	placePlayer(g, shooter, 100, 60)
+	placePlayer(g, victim, 101, 60)
+
+	g.killPlayer(victim, shooter.ID, clock.Now())
+	if g.world.occupant(101, 60) != nil {
+		t.Fatalf("dead player still occupies its cell")
+	}
+	if got := errorCode(g.QueueMove(victim.ID, "up", 0, nil)); got != ERR_DEAD {
+		t.Fatalf("dead player acting: error code = %q, want %q", got, ERR_DEAD)
+	}
+
+	advance(g, clock, RESPAWN_TIME-TICK_INTERVAL)
+	if !victim.Dead {
+		t.Fatalf("respawned before RESPAWN_TIME")
+	}
+
+	advance(g, clock, TICK_INTERVAL)
+	if victim.Dead {
+		t.Fatalf("not respawned after RESPAWN_TIME")
+	}
+	if !victim.protected(clock.Now()) {
+		t.Fatalf("respawned player is not spawn protected")
+	}
+	if g.world.occupant(victim.X, victim.Y) != victim {
+		t.Fatalf("respawned player missing from the occupancy index")
+	}
+
+	inZone := false
+	for _, zone := range g.world.Map.SpawnZones {
+		inZone = inZone || zone.contains(victim.X, victim.Y)
+	}
+	if !inZone {
+		t.Fatalf("respawned at (%d,%d), outside every spawn zone", victim.X, victim.Y)
+	}
+}
+
+func TestRespawnIsDeterministic(t *testing.T) {
+	positions := make([][2]int, 0, 2)
+	for run := 0; run < 2; run++ {
+		g, clock := newTestGame()
+		shooter := joinTestPlayer(t, g, "shooter", "S")
+		victim := joinTestPlayer(t, g, "victim", "V")
+		g.killPlayer(victim, shooter.ID, clock.Now())
+		advance(g, clock, RESPAWN_TIME)
+		positions = append(positions, [2]int{victim.X, victim.Y})
+	}
+
+	if positions[0] != positions[1] {
+		t.Fatalf("same seed respawned at %v and %v", positions[0], positions[1])
+	}
+}
+
+func TestLeaderboard(t *testing.T) {
+	tests := []struct {
+		name    string
+		players []Player
+		want    []LeaderboardEntry
+	}{
+		{
+			name: "kills then deaths",
+			players: []Player{
+				{ID: "p1", Name: "ana", Kills: 2, Deaths: 4},
+				{ID: "p2", Name: "bia", Kills: 5, Deaths: 2},
+				{ID: "p3", Name: "caio", Kills: 2, Deaths: 1},
+			},
+			want: []LeaderboardEntry{
+				{Rank: 1, Name: "bia", Kills: 5, Deaths: 2, KDR: "2.50"},
+				{Rank: 2, Name: "caio", Kills: 2, Deaths: 1, KDR: "2.00"},
+				{Rank: 3, Name: "ana", Kills: 2, Deaths: 4, KDR: "0.50"},
+			},
+		},
+		{
+			name: "no deaths",
+			players: []Player{
+				{ID: "p1", Name: "ana", Kills: 3},
+				{ID: "p2", Name: "bia", Deaths: 3},
+			},
+			want: []LeaderboardEntry{
+				{Rank: 1, Name: "ana", Kills: 3, KDR: "3.00"},
+				{Rank: 2, Name: "bia", Deaths: 3, KDR: "0.00"},
+			},
+		},
+		{
+			name: "empty",
+			want: []LeaderboardEntry{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, _ := newTestGame()
+			for i := range tt.players {
+				g.players[tt.players[i].ID] = &tt.players[i]
+			}
+
+			got := g.Leaderboard()
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d entries, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("entry %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// TestConcurrentCombat is meant to be run with -race: every player moves
+// and shoots from its own goroutine while the game ticks.
+func TestConcurrentCombat(t *testing.T) {
+	g, clock := newTestGame()
+
+	players := make([]*Player, 0, 16)
+	for i := 0; i < 16; i++ {
+		players = append(players, joinTestPlayer(t, g, fmt.Sprintf("p%d", i), string(rune('a'+i))))
+	}
+
+	directions := []string{"up", "down", "left", "right"}
+	var wg sync.WaitGroup
+	for i, player := range players {
+		wg.Add(1)
+		go func(i int, player *Player) {
+			defer wg.Done()
+			for step := 0; step < 50; step++ {
+				g.QueueMove(player.ID, directions[(i+step)%4], 0, nil)
+				g.Shoot(player.ID, directions[(i*step)%4])
+				g.Capture()
+				g.PlayerList()
+			}
+		}(i, player)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for step := 0; step < 200; step++ {
+			clock.Advance(TICK_INTERVAL)
+			g.Tick()
+			time.Sleep(time.Microsecond)
+		}
+	}()
+	wg.Wait()
+
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	kills, deaths := 0, 0
+	for _, player := range g.players {
+		kills += player.Kills
+		deaths += player.Deaths
+		if !player.Dead && g.world.occupant(player.X, player.Y) != player {
+			t.Errorf("%s at (%d,%d) is missing from the occupancy index", player.ID, player.X, player.Y)
+		}
+	}
+	if kills != deaths {
+		t.Fatalf("%d kills but %d deaths", kills, deaths)
+	}
+}
+
+// givePowerUp drops a power-up to the right of the player and walks onto it.
+func givePowerUp(t *testing.T, g *Game, player *Player, kind string) {
+	t.Helper()
+
+	g.world.addItem(&Item{ID: "powerup_" + kind, Kind: kind, X: player.X + 1, Y: player.Y})
+	if err := g.movePlayer(player, "right"); err != nil {
+		t.Fatal(err)
+	}
+	if !player.hasEffect(kind, g.clock.Now()) {
+		t.Fatalf("%s not active after walking over it", kind)
+	}
+}
+
+func TestPowerUpSpawning(t *testing.T) {
+	g, clock := newTestGame()
+	g.config.MaxPowerUps = 2
+
+	advance(g, clock, POWER_UP_INTERVAL-TICK_INTERVAL)
+	if g.world.countItems(PowerUpKinds) != 0 {
+		t.Fatal("power-up spawned before the interval")
+	}
+	advance(g, clock, 5*POWER_UP_INTERVAL)
+	if g.world.countItems(PowerUpKinds) != 2 {
+		t.Fatalf("%d power-ups on the map, want the maximum of 2", g.world.countItems(PowerUpKinds))
+	}
+	for _, item := range g.Capture().Items {
+		if g.world.isWall(item.X, item.Y) || !strings.ContainsRune(reservedCharacters, rune(item.Glyph())) {
+			t.Fatalf("bad power-up %+v", item)
+		}
+	}
+
+	g, clock = newTestGame()
+	g.config.PowerUpInterval = 0
+	advance(g, clock, 2*POWER_UP_INTERVAL)
+	if g.world.countItems(PowerUpKinds) != 0 {
+		t.Fatal("power-ups spawned while disabled")
+	}
+}
+
+func TestPowerUpEffects(t *testing.T) {
+	g, clock := newTestGame()
+	player := joinTestPlayer(t, g, "player", "P")
+	other := joinTestPlayer(t, g, "other", "O")
+	placePlayer(g, player, 10, 10)
+	placePlayer(g, other, 30, 30)
+
+	givePowerUp(t, g, player, POWER_UP_SPEED)
+	for i := 0; i < 2*MAX_QUEUED_MOVES; i++ {
+		if err := g.QueueMove(player.ID, "down", 0, nil); err != nil {
+			t.Fatalf("queueing move %d with speed: %v", i, err)
+		}
+	}
+	clock.Advance(TICK_INTERVAL)
+	g.Tick()
+	if player.Y != 12 {
+		t.Fatalf("y = %d after one tick with speed, want 12", player.Y)
+	}
+
+	givePowerUp(t, g, player, POWER_UP_RAPID_FIRE)
+	g.Shoot(player.ID, "up")
+	clock.Advance(SHOOT_COOLDOWN / RAPID_FIRE_FACTOR)
+	if code := shootCode(g.Shoot(player.ID, "up")); code != "" {
+		t.Fatalf("shooting after half the cooldown with rapid fire: %q", code)
+	}
+
+	givePowerUp(t, g, player, POWER_UP_TRIPLE_SHOT)
+	bullets := len(g.world.Bullets)
+	clock.Advance(SHOOT_COOLDOWN)
+	g.Shoot(player.ID, "left")
+	if got := len(g.world.Bullets) - bullets; got != 3 {
+		t.Fatalf("triple shot fired %d bullets, want 3", got)
+	}
+
+	givePowerUp(t, g, player, POWER_UP_INVISIBILITY)
+	state := g.Capture()
+	if len(Conceal(state.Entities, other.ID)) != 1 || len(Conceal(state.Entities, player.ID)) != 2 {
+		t.Fatal("invisible player should only be shown to itself")
+	}
+	for _, entry := range g.PlayerList() {
+		if entry.ID == player.ID && (entry.Position != "(?,?)" || len(entry.Effects) != 4) {
+			t.Fatalf("player list entry %+v, want a hidden position and 4 effects", entry)
+		}
+	}
+	placePlayer(g, other, player.X+1, player.Y)
+	err := g.movePlayer(other, "left")
+	if errorCode(err) != ERR_BLOCKED || strings.Contains(err.Reason, player.Name) || strings.Contains(err.Reason, "(") {
+		t.Fatalf("bumping into an invisible player: %v, want a blocked move that does not name or place it", err)
+	}
+
+	advance(g, clock, POWER_UP_DURATION)
+	if len(player.Effects) != 0 {
+		t.Fatalf("effects %v still active after they ran out", player.Effects)
+	}
+}
+
+func TestShieldAbsorbsBullets(t *testing.T) {
+	g, clock := newTestGame()
+	shooter := joinTestPlayer(t, g, "shooter", "S")
+	target := joinTestPlayer(t, g, "target", "T")
+	placePlayer(g, shooter, 10, 10)
+	placePlayer(g, target, 14, 10)
+	givePowerUp(t, g, target, POWER_UP_SHIELD)
+
+	g.Shoot(shooter.ID, "right")
+	advance(g, clock, 10*BULLET_SPEED)
+	if target.Dead {
+		t.Fatal("shielded player was killed")
+	}
+
+	clock.Advance(POWER_UP_DURATION)
+	g.Shoot(shooter.ID, "right")
+	advance(g, clock, 10*BULLET_SPEED)
+	if !target.Dead || target.Effects != nil {
+		t.Fatalf("player survived after the shield ran out or kept its effects: %+v", target)
+	}
+}
+
+func TestPowerUpsSurviveRestart(t *testing.T) {
+	g, _ := newTestGame()
+	player := joinTestPlayer(t, g, "player", "P")
+	placePlayer(g, player, 10, 10)
+	givePowerUp(t, g, player, POWER_UP_SHIELD)
+	g.world.addItem(&Item{ID: "powerup_9", Kind: POWER_UP_SPEED, X: 20, Y: 20})
+
+	restored, clock := restoredGame(t, g)
+	if items := restored.Capture().Items; len(items) != 1 || items[0].Kind != POWER_UP_SPEED {
+		t.Fatalf("restored power-ups %+v", items)
+	}
+	reclaimed, err := restored.Reclaim(player.ReclaimToken())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reclaimed.hasEffect(POWER_UP_SHIELD, clock.Now().Add(POWER_UP_DURATION-time.Second)) {
+		t.Fatalf("shield %v did not keep its remaining time", reclaimed.Effects)
+	}
+}
+
+func TestMagazineAndReload(t *testing.T) {
+	g, clock := newTestGame()
+	player := joinTestPlayer(t, g, "player", "P")
+	placePlayer(g, player, 10, 10)
+
+	for i := 0; i < MAGAZINE_SIZE; i++ {
+		if code := shootCode(g.Shoot(player.ID, "up")); code != "" {
+			t.Fatalf("shot %d: %q", i, code)
+		}
+		clock.Advance(SHOOT_COOLDOWN)
+	}
+	if code := shootCode(g.Shoot(player.ID, "up")); code != ERR_RELOADING {
+		t.Fatalf("shooting with an empty magazine: %q, want %q", code, ERR_RELOADING)
+	}
+	result := g.Tick()
+	if state := result.Ammo[player.ID]; state.Ammo != 0 || state.SpareAmmo != SPARE_AMMO || state.ReloadMs <= 0 {
+		t.Fatalf("reported %+v, want an empty magazine reloading", state)
+	}
+
+	advance(g, clock, RELOAD_TIME)
+	if player.Ammo != MAGAZINE_SIZE || player.SpareAmmo != SPARE_AMMO-MAGAZINE_SIZE {
+		t.Fatalf("ammo %d+%d after reloading, want %d+%d", player.Ammo, player.SpareAmmo, MAGAZINE_SIZE, SPARE_AMMO-MAGAZINE_SIZE)
+	}
+	if code := errorCode(g.Reload(player.ID)); code != ERR_MAGAZINE_FULL {
+		t.Fatalf("reloading a full magazine: %q", code)
+	}
+
+	g.Shoot(player.ID, "up")
+	if err := g.Reload(player.ID); err != nil {
+		t.Fatal(err)
+	}
+	if code := errorCode(g.Reload(player.ID)); code != ERR_RELOADING {
+		t.Fatalf("reloading twice: %q", code)
+	}
+	advance(g, clock, RELOAD_TIME)
+	if player.Ammo != MAGAZINE_SIZE || player.SpareAmmo != SPARE_AMMO-MAGAZINE_SIZE-1 {
+		t.Fatalf("ammo %d+%d after topping up", player.Ammo, player.SpareAmmo)
+	}
+
+	player.Ammo = 0
+	if code := shootCode(g.Shoot(player.ID, "up")); code != ERR_RELOADING || !player.reloading(clock.Now()) {
+		t.Fatalf("shooting an empty magazine with spare rounds: %q, want %q and a reload", code, ERR_RELOADING)
+	}
+
+	player.Ammo, player.SpareAmmo, player.ReloadingUntil = 0, 0, time.Time{}
+	if code := shootCode(g.Shoot(player.ID, "up")); code != ERR_OUT_OF_AMMO {
+		t.Fatalf("shooting without ammo: %q", code)
+	}
+	if code := errorCode(g.Reload(player.ID)); code != ERR_OUT_OF_AMMO {
+		t.Fatalf("reloading without ammo: %q", code)
+	}
+
+	g, clock = newTestGame()
+	g.config.MagazineSize = 0
+	player = joinTestPlayer(t, g, "player", "P")
+	for i := 0; i < 2*MAGAZINE_SIZE; i++ {
+		clock.Advance(SHOOT_COOLDOWN)
+		if code := shootCode(g.Shoot(player.ID, "up")); code != "" {
+			t.Fatalf("shot %d with unlimited ammo: %q", i, code)
+		}
+	}
+}
+
+// giveCrate drops a crate to the right of the player and walks onto it.
+func giveCrate(t *testing.T, g *Game, player *Player, kind string) {
+	t.Helper()
+
+	g.world.addItem(&Item{ID: "crate_" + kind, Kind: kind, X: player.X + 1, Y: player.Y})
+	if err := g.movePlayer(player, "right"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCrates(t *testing.T) {
+	g, clock := newTestGame()
+	g.config.PowerUpInterval = 0
+	advance(g, clock, 3*CRATE_INTERVAL+TICK_INTERVAL)
+	if crates := g.world.countItems(CrateKinds); crates != 3 || len(g.world.items) != 3 {
+		t.Fatalf("%d crates among %d items, want 3", crates, len(g.world.items))
+	}
+
+	player := joinTestPlayer(t, g, "player", "P")
+	placePlayer(g, player, 10, 10)
+	player.Ammo, player.SpareAmmo = 0, 0
+	giveCrate(t, g, player, CRATE_AMMO)
+	if player.SpareAmmo != AMMO_CRATE_MAGAZINES*MAGAZINE_SIZE || !player.reloading(clock.Now()) {
+		t.Fatalf("spare ammo %d after an ammo crate, want %d and a reload", player.SpareAmmo, AMMO_CRATE_MAGAZINES*MAGAZINE_SIZE)
+	}
+
+	giveCrate(t, g, player, WEAPON_RIFLE)
+	if player.Weapon != WEAPON_RIFLE || player.Ammo != 3*MAGAZINE_SIZE || player.reloading(clock.Now()) {
+		t.Fatalf("after a rifle crate: %s with %d rounds", player.Weapon, player.Ammo)
+	}
+	g.Shoot(player.ID, "up")
+	clock.Advance(SHOOT_COOLDOWN / 2)
+	if code := shootCode(g.Shoot(player.ID, "up")); code != "" {
+		t.Fatalf("rifle shooting after half the cooldown: %q", code)
+	}
+
+	giveCrate(t, g, player, WEAPON_SHOTGUN)
+	bullets := len(g.world.Bullets)
+	clock.Advance(2 * SHOOT_COOLDOWN)
+	g.Shoot(player.ID, "left")
+	if got := len(g.world.Bullets) - bullets; got != 3 || player.Ammo != 5 {
+		t.Fatalf("shotgun fired %d bullets leaving %d rounds, want 3 and 5", got, player.Ammo)
+	}
+
+	restored, _ := restoredGame(t, g)
+	reclaimed, err := restored.Reclaim(player.ReclaimToken())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := restored.Ammo(reclaimed.ID); state.Weapon != WEAPON_SHOTGUN || state.Ammo != 5 || state.SpareAmmo != player.SpareAmmo {
+		t.Fatalf("restored %+v", state)
+	}
+
+	g.killPlayer(player, "", clock.Now())
+	advance(g, clock, RESPAWN_TIME+TICK_INTERVAL)
+	if player.Dead || player.Weapon != WEAPON_PISTOL || player.Ammo != MAGAZINE_SIZE || player.SpareAmmo != SPARE_AMMO {
+		t.Fatalf("respawned with %s and %d+%d rounds, want a loaded pistol", player.Weapon, player.Ammo, player.SpareAmmo)
+	}
+}
+
+func TestMagazineSizeChangeKeepsWeapons(t *testing.T) {
+	g, clock := newTestGame()
+	player := joinTestPlayer(t, g, "player", "P")
+	placePlayer(g, player, 10, 10)
+	giveCrate(t, g, player, WEAPON_RIFLE)
+
+	resize := func(size int) {
+		t.Helper()
+		config := g.Config()
+		config.MagazineSize = size
+		if _, err := g.Reconfigure(config); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(TICK_INTERVAL)
+		g.Tick()
+	}
+
+	resize(MAGAZINE_SIZE / 2)
+	if player.Weapon != WEAPON_RIFLE || player.Ammo != 3*MAGAZINE_SIZE/2 || player.SpareAmmo != SPARE_AMMO+3*MAGAZINE_SIZE/2 {
+		t.Fatalf("after halving the magazine: %s with %d+%d rounds", player.Weapon, player.Ammo, player.SpareAmmo)
+	}
+
+	resize(0)
+	if code := shootCode(g.Shoot(player.ID, "up")); code != "" || player.Weapon != WEAPON_RIFLE {
+		t.Fatalf("%s shooting with unlimited ammo: %q", player.Weapon, code)
+	}
+
+	resize(MAGAZINE_SIZE)
+	if player.Weapon != WEAPON_RIFLE || player.Ammo != 3*MAGAZINE_SIZE {
+		t.Fatalf("leaving unlimited ammo: %s with %d rounds, want a full rifle magazine", player.Weapon, player.Ammo)
+	}
+}
@@ -0,0 +1,186 @@
+package engine
+
+import (
+	"math/rand"
+)
+
+type GameWorld struct {
+	Width   int
+	Height  int
+	Map     MapData
+	Bullets map[string]*Bullet
+
+	walls     []bool
+	occupants []*Player
+	// items holds the power-ups and crates on the map by cell.
+	items map[int]*Item
+}
+
+func NewGameWorld(config Config) *GameWorld {
+	width, height := config.WorldWidth, config.WorldHeight
+	world := &GameWorld{
+		Width:     width,
+		Height:    height,
+		Map:       generateMap(width, height, config.MapSeed, config.MapCellsPerWall),
+		Bullets:   make(map[string]*Bullet),
+		walls:     make([]bool, width*height),
+		occupants: make([]*Player, width*height),
+		items:     make(map[int]*Item),
+	}
+
+	for _, wall := range world.Map.Walls {
+		for y := wall.Y; y < wall.Y+wall.Height; y++ {
+			for x := wall.X; x < wall.X+wall.Width; x++ {
+				world.walls[y*world.Width+x] = true
+			}
+		}
+	}
+
+	return world
+}
+
+func (gw *GameWorld) bulletList() []Bullet {
+	bullets := make([]Bullet, 0, len(gw.Bullets))
+	for _, bullet := range gw.Bullets {
+		bullets = append(bullets, *bullet)
+	}
+	return bullets
+}
+
+type Rect struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+func (r Rect) contains(x, y int) bool {
+	return x >= r.X && x < r.X+r.Width && y >= r.Y && y < r.Y+r.Height
+}
+
+func (r Rect) overlaps(other Rect) bool {
+	return r.X < other.X+other.Width && other.X < r.X+r.Width && r.Y < other.Y+other.Height && other.Y < r.Y+r.Height
+}
+
+type MapData struct {
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+	Walls      []Rect `json:"walls" desc:"Wall rectangles; walls block movement, bullets and vision"`
+	SpawnZones []Rect `json:"spawnZones" desc:"Areas players are spawned in; always free of walls"`
+}
+
+// spawnZones lays out a 4x2 grid of zones plus one in the middle of the
+// world, so players can be spawned away from each other.
+func spawnZones(width, height int) []Rect {
+	zoneWidth, zoneHeight := min(16, width/4), min(8, height/2)
+	zones := []Rect{{X: (width - zoneWidth) / 2, Y: (height - zoneHeight) / 2, Width: zoneWidth, Height: zoneHeight}}
+	for row := 0; row < 2; row++ {
+		for col := 0; col < 4; col++ {
+			zones = append(zones, Rect{
+				X:      width*(2*col+1)/8 - zoneWidth/2,
+				Y:      height*(2*row+1)/4 - zoneHeight/2,
+				Width:  zoneWidth,
+				Height: zoneHeight,
+			})
+		}
+	}
+	return zones
+}
+
+// generateMap scatters rectangular buildings across the world from a fixed
+// seed, so every server instance builds the same map. Spawn zones are kept
+// clear.
+func generateMap(width, height int, seed int64, cellsPerWall int) MapData {
+	rng := rand.New(rand.NewSource(seed))
+	gameMap := MapData{Width: width, Height: height, SpawnZones: spawnZones(width, height)}
+	if cellsPerWall == 0 {
+		return gameMap
+	}
+
+	count := width * height / cellsPerWall
+	for attempts := 0; len(gameMap.Walls) < count && attempts < count*10; attempts++ {
+		wall := Rect{Width: 2 + rng.Intn(11), Height: 1 + rng.Intn(6)}
+		if wall.Width >= width-2 || wall.Height >= height-2 {
+			continue
+		}
+		wall.X = 1 + rng.Intn(width-wall.Width-1)
+		wall.Y = 1 + rng.Intn(height-wall.Height-1)
+
+		// Leave a corridor of at least two cells around every building.
+		padded := Rect{X: wall.X - 2, Y: wall.Y - 2, Width: wall.Width + 4, Height: wall.Height + 4}
+		free := true
+		for _, zone := range gameMap.SpawnZones {
+			if padded.overlaps(zone) {
+				free = false
+				break
+			}
+		}
+		for _, other := range gameMap.Walls {
+			if padded.overlaps(other) {
+				free = false
+				break
+			}
+		}
+		if free {
+			gameMap.Walls = append(gameMap.Walls, wall)
+		}
+	}
+
+	return gameMap
+}
+
+func (gw *GameWorld) isWall(x, y int) bool {
+	if x < 0 || x >= gw.Width || y < 0 || y >= gw.Height {
+		return true
+	}
+	return gw.walls[y*gw.Width+x]
+}
+
+// lineOfSight walks the grid line between two cells (Bresenham) and reports
+// whether no wall lies strictly between them.
+func (gw *GameWorld) lineOfSight(x0, y0, x1, y1 int) bool {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+
+	err := dx + dy
+	x, y := x0, y0
+	for {
+		if x == x1 && y == y1 {
+			return true
+		}
+		if (x != x0 || y != y0) && gw.isWall(x, y) {
+			return false
+		}
+
+		e2 := 2 * err
+		if e2 >= dy {
+			err += dy
+			x += sx
+		}
+		if e2 <= dx {
+			err += dx
+			y += sy
+		}
+	}
+}
+
+func (gw *GameWorld) CanSee(fromX, fromY, toX, toY, radius int) bool {
+	dx, dy := toX-fromX, toY-fromY
+	if dx*dx+dy*dy > radius*radius {
+		return false
+	}
+	return gw.lineOfSight(fromX, fromY, toX, toY)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
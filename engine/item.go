@@ -0,0 +1,132 @@
+package engine
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+type ItemKind struct {
+	Kind  string `json:"kind"`
+	Glyph string `json:"glyph" desc:"Character the item is drawn with"`
+}
+
+// ItemKinds lists every power-up and crate. Their glyphs are reserved so no
+// player can look like one.
+var ItemKinds = append(append([]ItemKind{}, PowerUpKinds...), CrateKinds...)
+
+// Item is a power-up or crate lying on the map until a player walks over
+// it.
+type Item struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+	X    int    `json:"x"`
+	Y    int    `json:"y"`
+}
+
+func (item Item) Glyph() byte {
+	for _, kind := range ItemKinds {
+		if kind.Kind == item.Kind {
+			return kind.Glyph[0]
+		}
+	}
+	return '?'
+}
+
+func (gw *GameWorld) itemAt(x, y int) *Item {
+	return gw.items[y*gw.Width+x]
+}
+
+func (gw *GameWorld) addItem(item *Item) {
+	gw.items[item.Y*gw.Width+item.X] = item
+}
+
+func (gw *GameWorld) takeItem(x, y int) *Item {
+	cell := y*gw.Width + x
+	item := gw.items[cell]
+	delete(gw.items, cell)
+	return item
+}
+
+func (gw *GameWorld) itemList() []Item {
+	items := make([]Item, 0, len(gw.items))
+	for _, item := range gw.items {
+		items = append(items, *item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	return items
+}
+
+// countItems counts the items on the map of any of kinds.
+func (gw *GameWorld) countItems(kinds []ItemKind) int {
+	count := 0
+	for _, item := range gw.items {
+		for _, kind := range kinds {
+			if item.Kind == kind.Kind {
+				count++
+				break
+			}
+		}
+	}
+	return count
+}
+
+// spawnItem drops a random item of kinds on a random free cell every
+// interval while there are fewer than limit of them on the map. A zero
+// interval or limit turns spawning off. Called with the lock held.
+func (g *Game) spawnItem(prefix string, kinds []ItemKind, interval time.Duration, limit int, next *time.Time, now time.Time) bool {
+	if interval == 0 || limit == 0 {
+		return false
+	}
+	if next.IsZero() {
+		*next = now.Add(interval)
+	}
+	if now.Before(*next) {
+		return false
+	}
+	*next = now.Add(interval)
+	if g.world.countItems(kinds) >= limit {
+		return false
+	}
+
+	for i := 0; i < SPAWN_CANDIDATES; i++ {
+		x, y := g.rng.Intn(g.world.Width), g.rng.Intn(g.world.Height)
+		if !g.world.isFree(x, y) || g.world.itemAt(x, y) != nil {
+			continue
+		}
+		g.nextItemID++
+		item := &Item{
+			ID:   fmt.Sprintf("%s_%d", prefix, g.nextItemID),
+			Kind: kinds[g.rng.Intn(len(kinds))].Kind,
+			X:    x,
+			Y:    y,
+		}
+		g.world.addItem(item)
+		log.Printf("Spawned %s at (%d,%d)", item.Kind, x, y)
+		return true
+	}
+	return false
+}
+
+// spawnItems spawns power-ups and crates on their own schedules.
+func (g *Game) spawnItems(now time.Time) bool {
+	powerUp := g.spawnItem("powerup", PowerUpKinds, g.config.PowerUpInterval, g.config.MaxPowerUps, &g.nextPowerUp, now)
+	crate := g.spawnItem("crate", CrateKinds, g.config.CrateInterval, g.config.MaxCrates, &g.nextCrate, now)
+	return powerUp || crate
+}
+
+// pickUp applies the item on the player's cell, if any. Called with the
+// lock held.
+func (g *Game) pickUp(player *Player, now time.Time) {
+	item := g.world.takeItem(player.X, player.Y)
+	if item == nil {
+		return
+	}
+	if isPowerUp(item.Kind) {
+		g.applyPowerUp(player, item.Kind, now)
+	} else {
+		g.openCrate(player, item.Kind, now)
+	}
+	log.Printf("Player %s picked up %s", player.Name, item.Kind)
+}
@@ -0,0 +1,102 @@
+package engine
+
+import (
+	"math"
+	"time"
+)
+
+type positionSample struct {
+	X     int
+	Y     int
+	Alive bool
+}
+
+type historyFrame struct {
+	at        time.Time
+	positions map[string]positionSample
+}
+
+func (g *Game) recordHistory(now time.Time) {
+	positions := make(map[string]positionSample, len(g.players))
+	for _, player := range g.players {
+		if player.IsSpectator {
+			continue
+		}
+		positions[player.ID] = positionSample{X: player.X, Y: player.Y, Alive: !player.Dead}
+	}
+
+	g.history = append(g.history, historyFrame{at: now, positions: positions})
+
+	keep := int(g.config.MaxRewind/g.config.TickInterval) + 2
+	if len(g.history) > keep {
+		g.history = append(g.history[:0], g.history[len(g.history)-keep:]...)
+	}
+}
+
+func (g *Game) positionsAt(at time.Time) map[string]positionSample {
+	for i := len(g.history) - 1; i >= 0; i-- {
+		if !g.history[i].at.After(at) {
+			return g.history[i].positions
+		}
+	}
+
+	if len(g.history) > 0 {
+		return g.history[0].positions
+	}
+	return nil
+}
+
+func (g *Game) RecordRTT(playerID string, sample time.Duration) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	player, exists := g.players[playerID]
+	if !exists {
+		return
+	}
+
+	if player.rtt == 0 {
+		player.rtt = sample
+		return
+	}
+	player.rtt = time.Duration(float64(player.rtt)*(1-RTT_SMOOTHING) + float64(sample)*RTT_SMOOTHING)
+}
+
+// rewindFor is how far behind the server the player's view was: the
+// one-way latency, half its round-trip time, up to MaxRewind.
+func (g *Game) rewindFor(player *Player) time.Duration {
+	return time.Duration(math.Min(float64(player.rtt/2), float64(g.config.MaxRewind)))
+}
+
+// fastForwardBullet advances a bullet fired at viewTime through the steps it
+// would already have taken, testing each step against where players were at
+// that moment rather than where they are now. It returns the player that was
+// hit, if any, and whether the bullet is still flying. Bullets hitting an
+// invulnerable player are absorbed. A bullet still flying is
+// scheduled for its next step.
+func (g *Game) fastForwardBullet(bullet *Bullet, viewTime time.Time, now time.Time) (*Player, bool) {
+	at := viewTime.Add(g.config.BulletSpeed)
+	for ; !at.After(now); at = at.Add(g.config.BulletSpeed) {
+		bullet.X += bullet.DirX
+		bullet.Y += bullet.DirY
+
+		if g.world.isWall(bullet.X, bullet.Y) {
+			return nil, false
+		}
+
+		for id, sample := range g.positionsAt(at) {
+			if id == bullet.OwnerID || !sample.Alive || sample.X != bullet.X || sample.Y != bullet.Y {
+				continue
+			}
+			if victim, exists := g.players[id]; exists && !victim.Dead {
+				if victim.invulnerable(now) {
+					return nil, false
+				}
+				return victim, true
+			}
+		}
+	}
+
+	bullet.nextStep = at
+	return nil, true
+}
@@ -0,0 +1,89 @@
+package engine
+
+import "time"
+
+const (
+	POWER_UP_SPEED        = "speed"
+	POWER_UP_RAPID_FIRE   = "rapidFire"
+	POWER_UP_SHIELD       = "shield"
+	POWER_UP_TRIPLE_SHOT  = "tripleShot"
+	POWER_UP_INVISIBILITY = "invisibility"
+
+	SPEED_BOOST_FACTOR = 2
+	RAPID_FIRE_FACTOR  = 2
+)
+
+// PowerUpKinds lists every power-up, each equally likely to spawn.
+var PowerUpKinds = []ItemKind{
+	{POWER_UP_SPEED, "~"},
+	{POWER_UP_RAPID_FIRE, "!"},
+	{POWER_UP_SHIELD, "["},
+	{POWER_UP_TRIPLE_SHOT, "^"},
+	{POWER_UP_INVISIBILITY, "/"},
+}
+
+func isPowerUp(kind string) bool {
+	for _, k := range PowerUpKinds {
+		if k.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Player) hasEffect(kind string, now time.Time) bool {
+	return now.Before(p.Effects[kind])
+}
+
+// invulnerable reports whether bullets pass the player by, thanks to spawn
+// protection or a shield.
+func (p *Player) invulnerable(now time.Time) bool {
+	return p.protected(now) || p.hasEffect(POWER_UP_SHIELD, now)
+}
+
+func (p *Player) speedFactor(now time.Time) int {
+	if p.hasEffect(POWER_UP_SPEED, now) {
+		return SPEED_BOOST_FACTOR
+	}
+	return 1
+}
+
+// applyPowerUp gives the player the power-up's effect for PowerUpDuration.
+// Picking up one already active starts it over. Called with the lock held.
+func (g *Game) applyPowerUp(player *Player, kind string, now time.Time) {
+	if player.Effects == nil {
+		player.Effects = make(map[string]time.Time)
+	}
+	player.Effects[kind] = now.Add(g.config.PowerUpDuration)
+}
+
+// expireEffects drops the player's effects that ran out and reports whether
+// there were any. Called with the lock held.
+func (g *Game) expireEffects(player *Player, now time.Time) bool {
+	expired := false
+	for kind, until := range player.Effects {
+		if !now.Before(until) {
+			delete(player.Effects, kind)
+			expired = true
+		}
+	}
+	return expired
+}
+
+// Conceal hides invisible players from everyone but themselves.
+func Conceal(entities []EntityState, viewerID string) []EntityState {
+	for i, entity := range entities {
+		if !entity.Invisible || entity.ID == viewerID {
+			continue
+		}
+
+		shown := append(make([]EntityState, 0, len(entities)-1), entities[:i]...)
+		for _, entity := range entities[i+1:] {
+			if !entity.Invisible || entity.ID == viewerID {
+				shown = append(shown, entity)
+			}
+		}
+		return shown
+	}
+	return entities
+}
@@ -0,0 +1,265 @@
+package engine
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	RECLAIM_WINDOW      = 2 * time.Minute
+	RECLAIM_TOKEN_BYTES = 16
+)
+
+// Snapshot is a game saved for a restart. Times are stored relative to
+// SavedAt so that cooldowns, respawns and bullets resume where they were on
+// the new server's clock. Tick is the match clock.
+type Snapshot struct {
+	SavedAt      time.Time     `json:"savedAt"`
+	Tick         uint64        `json:"tick"`
+	NextPlayerID uint64        `json:"nextPlayerId"`
+	NextBulletID uint64        `json:"nextBulletId"`
+	NextItemID   uint64        `json:"nextItemId,omitempty"`
+	Players      []SavedPlayer `json:"players"`
+	Bullets      []SavedBullet `json:"bullets"`
+	Items        []Item        `json:"items,omitempty"`
+}
+
+type SavedPlayer struct {
+	ID           string         `json:"id"`
+	Name         string         `json:"name"`
+	Character    string         `json:"character"`
+	X            int            `json:"x"`
+	Y            int            `json:"y"`
+	Kills        int            `json:"kills"`
+	Deaths       int            `json:"deaths"`
+	Dead         bool           `json:"dead"`
+	Spectator    bool           `json:"spectator"`
+	ReclaimToken string         `json:"reclaimToken"`
+	RespawnIn    *time.Duration `json:"respawnIn,omitempty"`
+	ProtectedFor *time.Duration `json:"protectedFor,omitempty"`
+	LastShotAgo  *time.Duration `json:"lastShotAgo,omitempty"`
+	// Effects holds how long each active power-up effect has left.
+	Effects   map[string]time.Duration `json:"effects,omitempty"`
+	Weapon    string                   `json:"weapon,omitempty"`
+	Ammo      int                      `json:"ammo"`
+	SpareAmmo int                      `json:"spareAmmo"`
+	ReloadIn  *time.Duration           `json:"reloadIn,omitempty"`
+}
+
+type SavedBullet struct {
+	ID         string        `json:"id"`
+	X          int           `json:"x"`
+	Y          int           `json:"y"`
+	DirX       int           `json:"dirX"`
+	DirY       int           `json:"dirY"`
+	OwnerID    string        `json:"ownerId"`
+	Character  string        `json:"character"`
+	NextStepIn time.Duration `json:"nextStepIn"`
+}
+
+func newReclaimToken() string {
+	token := make([]byte, RECLAIM_TOKEN_BYTES)
+	if _, err := rand.Read(token); err != nil {
+		panic(fmt.Sprintf("reading random reclaim token: %v", err))
+	}
+	return hex.EncodeToString(token)
+}
+
+// ReclaimToken is the secret a player presents to Reclaim its state after
+// a restart.
+func (p *Player) ReclaimToken() string {
+	return p.reclaimToken
+}
+
+// until and since turn absolute times into offsets from now and back; the
+// zero time, meaning never, has no offset.
+func until(t, now time.Time) *time.Duration {
+	if t.IsZero() {
+		return nil
+	}
+	offset := t.Sub(now)
+	return &offset
+}
+
+func since(offset *time.Duration, now time.Time) time.Time {
+	if offset == nil {
+		return time.Time{}
+	}
+	return now.Add(*offset)
+}
+
+func (g *Game) Snapshot() Snapshot {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	now := g.clock.Now()
+	snapshot := Snapshot{
+		SavedAt:      now,
+		Tick:         g.tickCount,
+		NextPlayerID: g.nextPlayerID,
+		NextBulletID: g.nextBulletID,
+
+		NextItemID: g.nextItemID,
+		Items:      g.world.itemList(),
+	}
+	for _, player := range g.playersByID() {
+		var effects map[string]time.Duration
+		for kind, until := range player.Effects {
+			if now.Before(until) {
+				if effects == nil {
+					effects = make(map[string]time.Duration, len(player.Effects))
+				}
+				effects[kind] = until.Sub(now)
+			}
+		}
+		snapshot.Players = append(snapshot.Players, SavedPlayer{
+			ID:           player.ID,
+			Name:         player.Name,
+			Character:    player.Character,
+			X:            player.X,
+			Y:            player.Y,
+			Kills:        player.Kills,
+			Deaths:       player.Deaths,
+			Dead:         player.Dead,
+			Spectator:    player.IsSpectator,
+			ReclaimToken: player.reclaimToken,
+			RespawnIn:    until(player.RespawnAt, now),
+			ProtectedFor: until(player.ProtectedUntil, now),
+			LastShotAgo:  until(player.LastShot, now),
+			Effects:      effects,
+			Weapon:       player.Weapon,
+			Ammo:         player.Ammo,
+			SpareAmmo:    player.SpareAmmo,
+			ReloadIn:     until(player.ReloadingUntil, now),
+		})
+	}
+	for _, bullet := range g.world.bulletList() {
+		snapshot.Bullets = append(snapshot.Bullets, SavedBullet{
+			ID:         bullet.ID,
+			X:          bullet.X,
+			Y:          bullet.Y,
+			DirX:       bullet.DirX,
+			DirY:       bullet.DirY,
+			OwnerID:    bullet.OwnerID,
+			Character:  bullet.Character,
+			NextStepIn: bullet.nextStep.Sub(now),
+		})
+	}
+	return snapshot
+}
+
+// Restore resumes a saved match in a game nobody has joined yet. Saved
+// players stay off the grid until they Reclaim their state, which they can
+// do for RECLAIM_WINDOW; until then their names and characters are
+// reserved. Bullets and items that no longer fit the map are dropped.
+func (g *Game) Restore(snapshot Snapshot) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := g.clock.Now()
+	g.tickCount = snapshot.Tick
+	g.nextPlayerID = snapshot.NextPlayerID
+	g.nextBulletID = snapshot.NextBulletID
+	g.nextItemID = snapshot.NextItemID
+	g.reclaimable = make(map[string]*Player, len(snapshot.Players))
+	g.reclaimUntil = now.Add(RECLAIM_WINDOW)
+
+	for _, saved := range snapshot.Players {
+		var effects map[string]time.Time
+		for kind, left := range saved.Effects {
+			if effects == nil {
+				effects = make(map[string]time.Time, len(saved.Effects))
+			}
+			effects[kind] = now.Add(left)
+		}
+		player := &Player{
+			ID:             saved.ID,
+			Name:           saved.Name,
+			Character:      saved.Character,
+			X:              saved.X,
+			Y:              saved.Y,
+			Kills:          saved.Kills,
+			Deaths:         saved.Deaths,
+			Dead:           saved.Dead,
+			IsSpectator:    saved.Spectator,
+			RespawnAt:      since(saved.RespawnIn, now),
+			ProtectedUntil: since(saved.ProtectedFor, now),
+			LastShot:       since(saved.LastShotAgo, now),
+			Effects:        effects,
+			Weapon:         saved.Weapon,
+			Ammo:           saved.Ammo,
+			SpareAmmo:      saved.SpareAmmo,
+			ReloadingUntil: since(saved.ReloadIn, now),
+			reclaimToken:   saved.ReclaimToken,
+		}
+		// Snapshots from before ammo existed carry no weapon.
+		if player.Weapon == "" && !player.IsSpectator {
+			g.arm(player)
+		}
+		g.reclaimable[saved.ReclaimToken] = player
+	}
+
+	for _, saved := range snapshot.Bullets {
+		if g.world.isWall(saved.X, saved.Y) {
+			continue
+		}
+		g.world.Bullets[saved.ID] = &Bullet{
+			ID:        saved.ID,
+			X:         saved.X,
+			Y:         saved.Y,
+			DirX:      saved.DirX,
+			DirY:      saved.DirY,
+			OwnerID:   saved.OwnerID,
+			Character: saved.Character,
+			nextStep:  now.Add(saved.NextStepIn),
+		}
+	}
+
+	for _, saved := range snapshot.Items {
+		if g.world.isWall(saved.X, saved.Y) || g.world.itemAt(saved.X, saved.Y) != nil {
+			continue
+		}
+		item := saved
+		g.world.addItem(&item)
+	}
+
+	log.Printf("Restored tick %d with %d players waiting to reclaim, %d bullets and %d items", g.tickCount, len(g.reclaimable), len(g.world.Bullets), len(g.world.items))
+}
+
+// Reclaim puts a restored player back in the game, where it was if that
+// cell is still free.
+func (g *Game) Reclaim(token string) (*Player, *GameError) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := g.clock.Now()
+	player, exists := g.reclaimable[token]
+	if !exists || now.After(g.reclaimUntil) {
+		return nil, &GameError{Code: ERR_RECLAIM_FAILED, Reason: "unknown or expired reclaim token"}
+	}
+	delete(g.reclaimable, token)
+
+	player.LastSeen = now
+	if !player.Dead && !player.IsSpectator {
+		if g.world.isFree(player.X, player.Y) {
+			g.world.place(player)
+		} else {
+			g.spawn(player, now)
+		}
+	}
+	g.players[player.ID] = player
+
+	return player, nil
+}
+
+// expireReclaims forgets restored players that never came back. Called
+// with the lock held.
+func (g *Game) expireReclaims(now time.Time) {
+	if len(g.reclaimable) > 0 && now.After(g.reclaimUntil) {
+		log.Printf("%d restored players did not reconnect in time", len(g.reclaimable))
+		g.reclaimable = nil
+	}
+}
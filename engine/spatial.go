@@ -0,0 +1,48 @@
+package engine
+
+// The occupancy index maps every cell to the living, non-spectating player
+// standing on it, so movement, bullet and spawn checks don't have to scan
+// all players. Callers hold g.mutex and must keep it in sync: place a
+// player when it appears on the grid, vacate it before it moves, dies,
+// leaves or starts spectating.
+
+func (gw *GameWorld) occupant(x, y int) *Player {
+	if x < 0 || x >= gw.Width || y < 0 || y >= gw.Height {
+		return nil
+	}
+	return gw.occupants[y*gw.Width+x]
+}
+
+func (gw *GameWorld) place(player *Player) {
+	gw.occupants[player.Y*gw.Width+player.X] = player
+}
+
+func (gw *GameWorld) vacate(player *Player) {
+	cell := player.Y*gw.Width + player.X
+	if gw.occupants[cell] == player {
+		gw.occupants[cell] = nil
+	}
+}
+
+func (gw *GameWorld) isFree(x, y int) bool {
+	return !gw.isWall(x, y) && gw.occupant(x, y) == nil
+}
+
+// nearestFreeCell searches square rings of growing radius around (x, y)
+// for a cell that is neither a wall nor occupied.
+func (gw *GameWorld) nearestFreeCell(x, y int) (int, int, bool) {
+	limit := max(gw.Width, gw.Height)
+	for radius := 0; radius < limit; radius++ {
+		for dy := -radius; dy <= radius; dy++ {
+			for dx := -radius; dx <= radius; dx++ {
+				if max(abs(dx), abs(dy)) != radius {
+					continue
+				}
+				if gw.isFree(x+dx, y+dy) {
+					return x + dx, y + dy, true
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,94 @@
+package engine
+
+import (
+	"math"
+	"time"
+)
+
+func (p *Player) protected(now time.Time) bool {
+	return now.Before(p.ProtectedUntil)
+}
+
+// bulletThreat reports whether a bullet will pass through (x, y) within
+// SPAWN_BULLET_LOOKAHEAD steps.
+func bulletThreat(bullet *Bullet, x, y int) bool {
+	for step := 1; step <= SPAWN_BULLET_LOOKAHEAD; step++ {
+		if bullet.X+bullet.DirX*step == x && bullet.Y+bullet.DirY*step == y {
+			return true
+		}
+	}
+	return false
+}
+
+// chooseSpawn samples free cells from the map's spawn zones and keeps the
+// one farthest from any living enemy, skipping cells an existing bullet is
+// about to cross. Called with the lock held.
+func (g *Game) chooseSpawn(player *Player) (int, int, bool) {
+	zones := g.world.Map.SpawnZones
+	bestX, bestY, found := 0, 0, false
+	bestScore := math.Inf(-1)
+
+	for i := 0; i < SPAWN_CANDIDATES && len(zones) > 0; i++ {
+		zone := zones[g.rng.Intn(len(zones))]
+		x := zone.X + g.rng.Intn(zone.Width)
+		y := zone.Y + g.rng.Intn(zone.Height)
+		if !g.world.isFree(x, y) {
+			continue
+		}
+
+		threatened := false
+		for _, bullet := range g.world.Bullets {
+			if bulletThreat(bullet, x, y) {
+				threatened = true
+				break
+			}
+		}
+		if threatened {
+			continue
+		}
+
+		score := math.Inf(1)
+		for _, other := range g.players {
+			if other == player || other.Dead || other.IsSpectator {
+				continue
+			}
+			score = math.Min(score, math.Hypot(float64(other.X-x), float64(other.Y-y)))
+		}
+
+		if score > bestScore {
+			bestX, bestY, bestScore, found = x, y, score, true
+		}
+	}
+
+	if !found {
+		return g.world.nearestFreeCell(g.world.Width/2, g.world.Height/2)
+	}
+	return bestX, bestY, true
+}
+
+// spawn puts a player on the grid at the best spawn point and grants it
+// spawn protection. Called with the lock held.
+func (g *Game) spawn(player *Player, now time.Time) {
+	if x, y, found := g.chooseSpawn(player); found {
+		player.X, player.Y = x, y
+	}
+	player.Dead = false
+	player.ProtectedUntil = now.Add(g.config.SpawnProtection)
+	g.world.place(player)
+}
+
+// Blink hides protected players on every other PROTECTION_BLINK_TICKS
+// ticks, which is how spawn protection shows up in the render.
+func Blink(entities []EntityState, tick uint64) []EntityState {
+	if (tick/PROTECTION_BLINK_TICKS)%2 == 0 {
+		return entities
+	}
+
+	drawn := make([]EntityState, 0, len(entities))
+	for _, entity := range entities {
+		if !entity.Protected {
+			drawn = append(drawn, entity)
+		}
+	}
+	return drawn
+}
@@ -0,0 +1,101 @@
+package engine
+
+import (
+	"strings"
+)
+
+type Viewport struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+func (v Viewport) Contains(x, y int) bool {
+	return x >= v.X && x < v.X+v.Width && y >= v.Y && y < v.Y+v.Height
+}
+
+func ClampViewportSize(width, height int) (int, int) {
+	if width <= 0 || height <= 0 {
+		return DEFAULT_VIEWPORT_WIDTH, DEFAULT_VIEWPORT_HEIGHT
+	}
+	width = max(MIN_VIEWPORT_WIDTH, min(MAX_VIEWPORT_WIDTH, width))
+	height = max(MIN_VIEWPORT_HEIGHT, min(MAX_VIEWPORT_HEIGHT, height))
+	return width, height
+}
+
+func scrollAxis(origin, size, target, worldSize int) int {
+	margin := min(SCROLL_MARGIN, size/4)
+	if target < origin+margin {
+		origin = target - margin
+	}
+	if target > origin+size-1-margin {
+		origin = target - size + 1 + margin
+	}
+	return max(0, min(worldSize-size, origin))
+}
+
+// FollowCamera moves the camera only when the target gets within
+// SCROLL_MARGIN cells of an edge, so small movements don't scroll the view.
+// A camera without a size yet is centered on the target.
+func FollowCamera(camera Viewport, width, height, targetX, targetY, worldWidth, worldHeight int) Viewport {
+	width, height = min(width, worldWidth), min(height, worldHeight)
+	if camera.Width == 0 || camera.Height == 0 {
+		camera.X, camera.Y = targetX-width/2, targetY-height/2
+	}
+	camera.Width, camera.Height = width, height
+
+	camera.X = scrollAxis(camera.X, width, targetX, worldWidth)
+	camera.Y = scrollAxis(camera.Y, height, targetY, worldHeight)
+	return camera
+}
+
+// RenderViewport draws the cells inside view: walls, then items, then
+// bullets, then players.
+func (gw *GameWorld) RenderViewport(view Viewport, entities []EntityState, bullets []Bullet, items []Item) []byte {
+	cells := make([]byte, view.Width*view.Height)
+	for y := 0; y < view.Height; y++ {
+		for x := 0; x < view.Width; x++ {
+			cells[y*view.Width+x] = ' '
+			if gw.isWall(view.X+x, view.Y+y) {
+				cells[y*view.Width+x] = '#'
+			}
+		}
+	}
+
+	for _, item := range items {
+		if view.Contains(item.X, item.Y) {
+			cells[(item.Y-view.Y)*view.Width+item.X-view.X] = item.Glyph()
+		}
+	}
+
+	for _, bullet := range bullets {
+		if view.Contains(bullet.X, bullet.Y) {
+			cells[(bullet.Y-view.Y)*view.Width+bullet.X-view.X] = '*'
+		}
+	}
+
+	for _, entity := range entities {
+		if !entity.Dead && entity.Character != "" && view.Contains(entity.X, entity.Y) {
+			cells[(entity.Y-view.Y)*view.Width+entity.X-view.X] = entity.Character[0]
+		}
+	}
+
+	return cells
+}
+
+func RenderText(cells []byte, width, height int) string {
+	var builder strings.Builder
+	builder.Grow((width + 3) * (height + 2))
+	builder.WriteString("+" + strings.Repeat("-", width) + "+\n")
+
+	for y := 0; y < height; y++ {
+		builder.WriteString("|")
+		builder.Write(cells[y*width : (y+1)*width])
+		builder.WriteString("|\n")
+	}
+
+	builder.WriteString("+" + strings.Repeat("-", width) + "+\n")
+
+	return builder.String()
+}
@@ -0,0 +1,232 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	WEAPON_PISTOL  = "pistol"
+	WEAPON_RIFLE   = "rifle"
+	WEAPON_SHOTGUN = "shotgun"
+
+	CRATE_AMMO = "ammo"
+	// AMMO_CRATE_MAGAZINES is how many magazines of the player's weapon an
+	// ammo crate holds.
+	AMMO_CRATE_MAGAZINES = 2
+)
+
+// Weapon scales the pistol's magazineSize, shootCooldown and reloadTime
+// knobs.
+type Weapon struct {
+	Magazine float64
+	Cooldown float64
+	Reload   float64
+	// Spread fires three bullets in a fan, like triple shot.
+	Spread bool
+}
+
+// Weapons are what players fight with. Everyone spawns with a pistol; the
+// others come in crates.
+var Weapons = map[string]Weapon{
+	WEAPON_PISTOL:  {Magazine: 1, Cooldown: 1, Reload: 1},
+	WEAPON_RIFLE:   {Magazine: 3, Cooldown: 0.4, Reload: 1.5},
+	WEAPON_SHOTGUN: {Magazine: 0.75, Cooldown: 2, Reload: 1.5, Spread: true},
+}
+
+// CrateKinds lists the ammo crate and a crate for each weapon found on the
+// map, each equally likely to spawn.
+var CrateKinds = []ItemKind{
+	{CRATE_AMMO, ")"},
+	{WEAPON_RIFLE, "}"},
+	{WEAPON_SHOTGUN, "{"},
+}
+
+// AmmoState is what a player's HUD shows about its weapon.
+type AmmoState struct {
+	Weapon    string `json:"weapon"`
+	Ammo      int    `json:"ammo" desc:"Rounds in the magazine"`
+	Magazine  int    `json:"magazine" desc:"Rounds a full magazine holds; 0 means unlimited ammo"`
+	SpareAmmo int    `json:"spareAmmo" desc:"Rounds carried besides the magazine"`
+	ReloadMs  int64  `json:"reloadMs,omitempty" desc:"Milliseconds until the reload in progress finishes"`
+}
+
+func (p *Player) reloading(now time.Time) bool {
+	return now.Before(p.ReloadingUntil)
+}
+
+func (p *Player) weapon() Weapon {
+	if weapon, ok := Weapons[p.Weapon]; ok {
+		return weapon
+	}
+	return Weapons[WEAPON_PISTOL]
+}
+
+// magazineSize is how many rounds the player's weapon holds, 0 when ammo is
+// unlimited.
+func (g *Game) magazineSize(player *Player) int {
+	if g.config.MagazineSize == 0 {
+		return 0
+	}
+	return max(1, int(math.Round(float64(g.config.MagazineSize)*player.weapon().Magazine)))
+}
+
+func (g *Game) shootCooldown(player *Player, now time.Time) time.Duration {
+	cooldown := time.Duration(float64(g.config.ShootCooldown) * player.weapon().Cooldown)
+	if player.hasEffect(POWER_UP_RAPID_FIRE, now) {
+		cooldown /= RAPID_FIRE_FACTOR
+	}
+	return cooldown
+}
+
+// arm hands the player a loaded pistol and SpareAmmo. Called with the lock
+// held.
+func (g *Game) arm(player *Player) {
+	player.Weapon = WEAPON_PISTOL
+	player.Ammo = g.magazineSize(player)
+	player.SpareAmmo = g.config.SpareAmmo
+	player.ReloadingUntil = time.Time{}
+	player.armsChanged = true
+}
+
+// resizeMagazine fits the player's magazine to a new magazine size and
+// keeps its weapon. Rounds that no longer fit go back to the spare ammo;
+// coming from unlimited ammo, the magazine is loaded. Called with the lock
+// held.
+func (g *Game) resizeMagazine(player *Player, wasUnlimited bool) {
+	size := g.magazineSize(player)
+	switch {
+	case size == 0 || wasUnlimited:
+		player.Ammo = size
+		player.ReloadingUntil = time.Time{}
+	case player.Ammo > size:
+		player.SpareAmmo += player.Ammo - size
+		player.Ammo = size
+	}
+	player.armsChanged = true
+}
+
+// startReload begins refilling the magazine from the spare rounds. Called
+// with the lock held.
+func (g *Game) startReload(player *Player, now time.Time) *GameError {
+	switch {
+	case player.reloading(now):
+		return &GameError{Code: ERR_RELOADING, Reason: fmt.Sprintf("already reloading (%dms)", player.ReloadingUntil.Sub(now).Milliseconds())}
+	case g.config.MagazineSize == 0:
+		return &GameError{Code: ERR_MAGAZINE_FULL, Reason: "ammo is unlimited"}
+	case player.Ammo >= g.magazineSize(player):
+		return &GameError{Code: ERR_MAGAZINE_FULL, Reason: "magazine is already full"}
+	case player.SpareAmmo == 0:
+		return &GameError{Code: ERR_OUT_OF_AMMO, Reason: "no spare ammo, find an ammo crate"}
+	}
+
+	player.ReloadingUntil = now.Add(time.Duration(float64(g.config.ReloadTime) * player.weapon().Reload))
+	player.armsChanged = true
+	return nil
+}
+
+// Reload starts reloading the player's weapon. The magazine is filled once
+// the weapon's reload time has passed; the player cannot shoot until then.
+func (g *Game) Reload(playerID string) *GameError {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	player, err := g.checkCanAct(playerID)
+	if err != nil {
+		return err
+	}
+	now := g.clock.Now()
+	player.LastSeen = now
+	return g.startReload(player, now)
+}
+
+// finishReload fills the magazine once the player's reload is done. Called
+// with the lock held.
+func (g *Game) finishReload(player *Player, now time.Time) {
+	if player.ReloadingUntil.IsZero() || player.reloading(now) {
+		return
+	}
+	player.ReloadingUntil = time.Time{}
+	if loaded := min(g.magazineSize(player)-player.Ammo, player.SpareAmmo); loaded > 0 {
+		player.Ammo += loaded
+		player.SpareAmmo -= loaded
+	}
+	player.armsChanged = true
+}
+
+// openCrate gives the player a crate's contents: spare rounds, or a loaded
+// weapon that replaces the current one and cancels its reload. Called with
+// the lock held.
+func (g *Game) openCrate(player *Player, kind string, now time.Time) {
+	if kind == CRATE_AMMO {
+		player.SpareAmmo += AMMO_CRATE_MAGAZINES * g.magazineSize(player)
+		if player.Ammo == 0 {
+			g.startReload(player, now)
+		}
+	} else {
+		player.Weapon = kind
+		player.Ammo = g.magazineSize(player)
+		player.ReloadingUntil = time.Time{}
+	}
+	player.armsChanged = true
+}
+
+// consumeRound spends a round of the player's magazine, reloading when it
+// runs dry. Called with the lock held.
+func (g *Game) consumeRound(player *Player, now time.Time) {
+	if g.config.MagazineSize == 0 {
+		return
+	}
+	player.Ammo--
+	player.armsChanged = true
+	if player.Ammo == 0 {
+		g.startReload(player, now)
+	}
+}
+
+func (g *Game) ammoState(player *Player, now time.Time) AmmoState {
+	state := AmmoState{
+		Weapon:    player.Weapon,
+		Ammo:      player.Ammo,
+		Magazine:  g.magazineSize(player),
+		SpareAmmo: player.SpareAmmo,
+	}
+	if player.reloading(now) {
+		state.ReloadMs = player.ReloadingUntil.Sub(now).Milliseconds()
+	}
+	return state
+}
+
+// Ammo returns what the player's HUD shows about its weapon. Spectators and
+// unknown players have none.
+func (g *Game) Ammo(playerID string) (AmmoState, bool) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	player, exists := g.players[playerID]
+	if !exists || player.IsSpectator {
+		return AmmoState{}, false
+	}
+	return g.ammoState(player, g.clock.Now()), true
+}
+
+// armsReport collects the HUD state of every player whose weapon or ammo
+// changed since the last report. Called with the lock held.
+func (g *Game) armsReport(now time.Time) map[string]AmmoState {
+	var report map[string]AmmoState
+	for _, player := range g.players {
+		if !player.armsChanged {
+			continue
+		}
+		player.armsChanged = false
+		if player.IsSpectator {
+			continue
+		}
+		if report == nil {
+			report = make(map[string]AmmoState)
+		}
+		report[player.ID] = g.ammoState(player, now)
+	}
+	return report
+}
@@ -2,4 +2,4 @@ module multiplayer-game
 
 go 1.24.6
 
-require github.com/gorilla/websocket v1.5.3 // indirect
+require github.com/gorilla/websocket v1.5.3
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+type ReloadReply struct {
+	Rooms map[string][]string `json:"rooms" desc:"Settings that will change in each room on its next tick"`
+}
+
+type adminError struct {
+	Error string `json:"error"`
+}
+
+// AdminHandler serves the admin endpoints under /admin/. Requests must
+// carry token as a bearer token; with an empty token every request is
+// refused.
+//
+// POST /admin/reload calls reload and replies with what changed.
+func AdminHandler(token string, reload func() (map[string][]string, error)) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeAdminJSON(w, http.StatusMethodNotAllowed, adminError{"use POST"})
+			return
+		}
+
+		changes, err := reload()
+		if err != nil {
+			log.Printf("Reload from %s failed: %v", r.RemoteAddr, err)
+			writeAdminJSON(w, http.StatusBadRequest, adminError{err.Error()})
+			return
+		}
+		log.Printf("Reload requested by %s", r.RemoteAddr)
+		writeAdminJSON(w, http.StatusOK, ReloadReply{Rooms: changes})
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			writeAdminJSON(w, http.StatusUnauthorized, adminError{"missing or wrong admin token"})
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"log"
+	"strings"
+	"unicode"
+
+	"multiplayer-game/engine"
+)
+
+// cleanChat turns control characters, which could mess with the client's
+// display, into spaces.
+func cleanChat(text string) string {
+	return strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, text))
+}
+
+// SetChatRelay makes global chat go through relay, which must deliver it to
+// every room, this one included. Without a relay global chat stays in the
+// room.
+func (s *Server) SetChatRelay(relay func(ChatMessage) error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.relay = relay
+}
+
+// chat sends what player said to the room, or to the relay when global.
+func (s *Server) chat(player *engine.Player, room string, data ChatData) *engine.GameError {
+	message := ChatMessage{From: player.Name, Character: player.Character, Room: room, Text: cleanChat(data.Text), Global: data.Global}
+	log.Printf("Chat from %s in %s: %s", player.Name, room, message.Text)
+
+	s.mutex.RLock()
+	relay := s.relay
+	s.mutex.RUnlock()
+	if !message.Global || relay == nil {
+		s.broadcast(Message{Type: "chat", Data: message})
+		return nil
+	}
+	if err := relay(message); err != nil {
+		log.Printf("Error relaying chat from %s: %v", player.Name, err)
+		return &engine.GameError{Code: ERR_UNAVAILABLE, Reason: "global chat is unavailable right now"}
+	}
+	return nil
+}
+
+// setGlobalLeaderboard remembers board for new players and sends it to the
+// current ones.
+func (s *Server) setGlobalLeaderboard(board []GlobalLeaderboardEntry) {
+	s.mutex.Lock()
+	s.globalBoard = board
+	s.mutex.Unlock()
+	s.broadcast(Message{Type: "globalLeaderboard", Data: board})
+}
@@ -0,0 +1,366 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"multiplayer-game/backplane"
+)
+
+const (
+	CHANNEL_ROOMS       = "arena:rooms"
+	CHANNEL_CHAT        = "arena:chat"
+	CHANNEL_LEADERBOARD = "arena:leaderboard"
+
+	ANNOUNCE_INTERVAL       = 2 * time.Second
+	ANNOUNCE_TTL            = 3 * ANNOUNCE_INTERVAL
+	GLOBAL_LEADERBOARD_SIZE = 10
+)
+
+// RoomInfo is where a room is hosted, as listed by GET /rooms.
+type RoomInfo struct {
+	Name     string `json:"name"`
+	Instance string `json:"instance"`
+	URL      string `json:"url" desc:"Where clients reach the instance hosting the room"`
+	Players  int    `json:"players"`
+}
+
+// announcement is what an instance publishes on CHANNEL_ROOMS every
+// ANNOUNCE_INTERVAL. An instance that is going away announces no rooms.
+type announcement struct {
+	Instance string     `json:"instance"`
+	Rooms    []RoomInfo `json:"rooms"`
+}
+
+// leaderboardUpdate is an instance's best players, published on
+// CHANNEL_LEADERBOARD with every announcement.
+type leaderboardUpdate struct {
+	Instance string                   `json:"instance"`
+	Entries  []GlobalLeaderboardEntry `json:"entries"`
+}
+
+type peer struct {
+	rooms []RoomInfo
+	board []GlobalLeaderboardEntry
+	seen  time.Time
+}
+
+// Cluster connects this instance's rooms to the other instances through a
+// backplane: each announces the rooms it hosts, global chat goes to every
+// room everywhere, and the best players of every room make up a global
+// leaderboard. A lone instance on a memory backplane is a cluster of one.
+type Cluster struct {
+	backplane backplane.Backplane
+	instance  string
+	url       string
+	rooms     *Rooms
+
+	peers map[string]*peer
+	board []GlobalLeaderboardEntry
+	mutex sync.Mutex
+	// sending orders leaderboard sends without holding mutex while
+	// writing to clients.
+	sending sync.Mutex
+
+	stop chan struct{}
+	once sync.Once
+	now  func() time.Time
+}
+
+// NewCluster joins rooms, hosted by instance and reachable at publicURL, to
+// the instances on bp.
+func NewCluster(bp backplane.Backplane, instance, publicURL string, rooms *Rooms) (*Cluster, error) {
+	c := &Cluster{
+		backplane: bp,
+		instance:  instance,
+		url:       strings.TrimSuffix(publicURL, "/"),
+		rooms:     rooms,
+		peers:     make(map[string]*peer),
+		stop:      make(chan struct{}),
+		now:       time.Now,
+	}
+	subscriptions := map[string]func([]byte){
+		CHANNEL_ROOMS:       c.onAnnouncement,
+		CHANNEL_LEADERBOARD: c.onLeaderboard,
+		CHANNEL_CHAT:        c.onChat,
+	}
+	for channel, handler := range subscriptions {
+		if err := bp.Subscribe(channel, handler); err != nil {
+			return nil, err
+		}
+	}
+	rooms.SetChatRelay(c.publishChat)
+	return c, nil
+}
+
+// Run announces this instance until Close.
+func (c *Cluster) Run() {
+	ticker := time.NewTicker(ANNOUNCE_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		c.announce()
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close tells the other instances this one is leaving and disconnects.
+func (c *Cluster) Close() error {
+	c.once.Do(func() { close(c.stop) })
+	c.publish(CHANNEL_ROOMS, announcement{Instance: c.instance})
+	return c.backplane.Close()
+}
+
+func (c *Cluster) publish(channel string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.backplane.Publish(channel, data)
+}
+
+// localRooms lists the rooms hosted here and their best players.
+func (c *Cluster) localRooms() ([]RoomInfo, []GlobalLeaderboardEntry) {
+	var rooms []RoomInfo
+	var board []GlobalLeaderboardEntry
+	for _, name := range c.rooms.Names() {
+		s, _ := c.rooms.Get(name)
+		rooms = append(rooms, RoomInfo{Name: name, Instance: c.instance, URL: c.url, Players: len(s.game.PlayerList())})
+		for i, entry := range s.game.Leaderboard() {
+			if i == GLOBAL_LEADERBOARD_SIZE {
+				break
+			}
+			board = append(board, GlobalLeaderboardEntry{
+				Name:      entry.Name,
+				Character: entry.Character,
+				Room:      name,
+				Kills:     entry.Kills,
+				Deaths:    entry.Deaths,
+				KDR:       entry.KDR,
+			})
+		}
+	}
+	return rooms, board
+}
+
+// announce publishes this instance's rooms and leaderboard and forgets
+// instances that stopped announcing.
+func (c *Cluster) announce() {
+	rooms, board := c.localRooms()
+	if err := c.publish(CHANNEL_ROOMS, announcement{Instance: c.instance, Rooms: rooms}); err != nil {
+		log.Printf("Error announcing rooms: %v", err)
+	}
+	if err := c.publish(CHANNEL_LEADERBOARD, leaderboardUpdate{Instance: c.instance, Entries: board}); err != nil {
+		log.Printf("Error publishing leaderboard: %v", err)
+	}
+
+	c.mutex.Lock()
+	now := c.now()
+	for instance, p := range c.peers {
+		if now.Sub(p.seen) > ANNOUNCE_TTL {
+			log.Printf("Instance %s stopped announcing", instance)
+			delete(c.peers, instance)
+		}
+	}
+	c.mutex.Unlock()
+	c.refreshLeaderboard()
+}
+
+// peer returns the state kept for instance, creating it. Called with the
+// lock held.
+func (c *Cluster) peer(instance string) *peer {
+	p, exists := c.peers[instance]
+	if !exists {
+		p = &peer{}
+		c.peers[instance] = p
+	}
+	p.seen = c.now()
+	return p
+}
+
+func (c *Cluster) onAnnouncement(data []byte) {
+	var a announcement
+	if err := json.Unmarshal(data, &a); err != nil || a.Instance == "" {
+		log.Printf("Ignoring bad room announcement: %v", err)
+		return
+	}
+
+	c.mutex.Lock()
+	if len(a.Rooms) == 0 {
+		delete(c.peers, a.Instance)
+	} else {
+		c.peer(a.Instance).rooms = a.Rooms
+	}
+	c.mutex.Unlock()
+	if len(a.Rooms) == 0 {
+		c.refreshLeaderboard()
+	}
+}
+
+func (c *Cluster) onLeaderboard(data []byte) {
+	var update leaderboardUpdate
+	if err := json.Unmarshal(data, &update); err != nil || update.Instance == "" {
+		log.Printf("Ignoring bad leaderboard update: %v", err)
+		return
+	}
+
+	c.mutex.Lock()
+	c.peer(update.Instance).board = update.Entries
+	c.mutex.Unlock()
+	c.refreshLeaderboard()
+}
+
+// refreshLeaderboard merges every instance's best players and sends the
+// result to the local rooms when it changed. Refreshes are serialized so
+// they reach clients in order, but the cluster is unlocked while sending,
+// so a slow client only holds up leaderboards.
+func (c *Cluster) refreshLeaderboard() {
+	c.sending.Lock()
+	defer c.sending.Unlock()
+
+	c.mutex.Lock()
+	var board []GlobalLeaderboardEntry
+	for _, p := range c.peers {
+		board = append(board, p.board...)
+	}
+	sort.Slice(board, func(i, j int) bool {
+		a, b := board[i], board[j]
+		if a.Kills != b.Kills {
+			return a.Kills > b.Kills
+		}
+		if a.Deaths != b.Deaths {
+			return a.Deaths < b.Deaths
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Room < b.Room
+	})
+	if len(board) > GLOBAL_LEADERBOARD_SIZE {
+		board = board[:GLOBAL_LEADERBOARD_SIZE]
+	}
+	for i := range board {
+		board[i].Rank = i + 1
+	}
+	changed := !reflect.DeepEqual(board, c.board)
+	if changed {
+		c.board = board
+	}
+	c.mutex.Unlock()
+
+	if changed {
+		c.rooms.setGlobalLeaderboard(board)
+	}
+}
+
+func (c *Cluster) publishChat(message ChatMessage) error {
+	return c.publish(CHANNEL_CHAT, message)
+}
+
+func (c *Cluster) onChat(data []byte) {
+	var message ChatMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		log.Printf("Ignoring bad chat message: %v", err)
+		return
+	}
+	c.rooms.deliverChat(message)
+}
+
+// Directory lists every room in the cluster by name. Rooms hosted here are
+// always current; when two instances claim the same room, this one wins,
+// then the first instance by name.
+func (c *Cluster) Directory() []RoomInfo {
+	local, _ := c.localRooms()
+	byName := make(map[string]RoomInfo)
+	for _, room := range local {
+		byName[room.Name] = room
+	}
+
+	c.mutex.Lock()
+	instances := make([]string, 0, len(c.peers))
+	for instance := range c.peers {
+		instances = append(instances, instance)
+	}
+	sort.Strings(instances)
+	for _, instance := range instances {
+		if instance == c.instance {
+			continue
+		}
+		for _, room := range c.peers[instance].rooms {
+			if _, taken := byName[room.Name]; !taken {
+				byName[room.Name] = room
+			}
+		}
+	}
+	c.mutex.Unlock()
+
+	rooms := make([]RoomInfo, 0, len(byName))
+	for _, room := range byName {
+		rooms = append(rooms, room)
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].Name < rooms[j].Name })
+	return rooms
+}
+
+// locate returns where a room hosted by another instance is.
+func (c *Cluster) locate(name string) (RoomInfo, bool) {
+	if _, local := c.rooms.Get(name); local {
+		return RoomInfo{}, false
+	}
+	for _, room := range c.Directory() {
+		if room.Name == name {
+			return room, room.URL != ""
+		}
+	}
+	return RoomInfo{}, false
+}
+
+// ServeDirectory serves GET /rooms, which routers and lobbies use to find
+// rooms.
+func (c *Cluster) ServeDirectory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(map[string][]RoomInfo{"rooms": c.Directory()})
+}
+
+// ServeLeaderboard serves GET /leaderboard with the global leaderboard.
+func (c *Cluster) ServeLeaderboard(w http.ResponseWriter, r *http.Request) {
+	c.mutex.Lock()
+	board := c.board
+	c.mutex.Unlock()
+	if board == nil {
+		board = []GlobalLeaderboardEntry{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(board)
+}
+
+// RedirectRemote sends browsers asking for a page of a room hosted by
+// another instance there, and lets every other request through to next.
+func (c *Cluster) RedirectRemote(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			name := r.URL.Query().Get("room")
+			if name == "" {
+				name = DEFAULT_ROOM
+			}
+			if room, ok := c.locate(name); ok {
+				http.Redirect(w, r, room.URL+"/?room="+url.QueryEscape(name), http.StatusFound)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
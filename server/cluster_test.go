@@ -0,0 +1,140 @@
+package server
+
+import (
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"multiplayer-game/backplane"
+	"multiplayer-game/engine"
+)
+
+// newTestInstance runs the given rooms as one instance of a cluster on bp.
+func newTestInstance(t *testing.T, bp backplane.Backplane, instance string, names ...string) (*Cluster, *Rooms) {
+	t.Helper()
+
+	servers := make(map[string]*Server, len(names))
+	for _, name := range names {
+		clock := engine.NewManualClock(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+		servers[name] = NewServer(engine.NewGame(engine.DefaultConfig(), clock, rand.New(rand.NewSource(1))))
+	}
+	rooms := NewRooms(servers)
+	c, err := NewCluster(bp, instance, "http://"+instance+".example/", rooms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, rooms
+}
+
+func joinRoom(t *testing.T, rooms *Rooms, room, name, character string) (*fakeConn, *engine.Player) {
+	t.Helper()
+
+	s, _ := rooms.Get(room)
+	conn := &fakeConn{}
+	sess := &session{version: PROTOCOL_VERSION, capabilities: map[string]bool{}}
+	player, err := s.addClient(conn, sess, JoinData{Name: name, Character: character})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, player
+}
+
+func TestClusterDirectory(t *testing.T) {
+	bp := backplane.NewMemory()
+	one, _ := newTestInstance(t, bp, "one", DEFAULT_ROOM)
+	two, _ := newTestInstance(t, bp, "two", "duel")
+	one.announce()
+	two.announce()
+
+	rooms := one.Directory()
+	if len(rooms) != 2 || rooms[0].Name != "duel" || rooms[0].Instance != "two" || rooms[1].Instance != "one" {
+		t.Fatalf("directory %+v, want duel on two and main on one", rooms)
+	}
+
+	recorder := httptest.NewRecorder()
+	one.RedirectRemote(http.NotFoundHandler()).ServeHTTP(recorder, httptest.NewRequest("GET", "/?room=duel", nil))
+	if recorder.Code != http.StatusFound || recorder.Header().Get("Location") != "http://two.example/?room=duel" {
+		t.Fatalf("got %d to %q, want a redirect to two", recorder.Code, recorder.Header().Get("Location"))
+	}
+	recorder = httptest.NewRecorder()
+	two.RedirectRemote(http.NotFoundHandler()).ServeHTTP(recorder, httptest.NewRequest("GET", "/?room=duel", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Fatalf("got %d, want the local room served", recorder.Code)
+	}
+
+	// An instance that stops announcing drops out, and so does one that
+	// leaves.
+	one.now = func() time.Time { return time.Now().Add(ANNOUNCE_TTL + time.Second) }
+	one.announce()
+	if rooms := one.Directory(); len(rooms) != 1 {
+		t.Fatalf("directory %+v after two went quiet", rooms)
+	}
+	one.now = time.Now
+	two.announce()
+	two.Close()
+	if rooms := one.Directory(); len(rooms) != 1 {
+		t.Fatalf("directory %+v after two left", rooms)
+	}
+}
+
+func TestClusterChat(t *testing.T) {
+	bp := backplane.NewMemory()
+	_, oneRooms := newTestInstance(t, bp, "one", DEFAULT_ROOM)
+	_, twoRooms := newTestInstance(t, bp, "two", "duel")
+	anaConn, ana := joinRoom(t, oneRooms, DEFAULT_ROOM, "ana", "A")
+	biaConn, _ := joinRoom(t, twoRooms, "duel", "bia", "B")
+
+	s, _ := oneRooms.Get(DEFAULT_ROOM)
+	if err := s.chat(ana, DEFAULT_ROOM, ChatData{Text: "oi\tsala"}); err != nil {
+		t.Fatal(err)
+	}
+	var message ChatMessage
+	if !findMessage(anaConn, "chat", &message) || message.Text != "oi sala" || message.Global {
+		t.Fatalf("room chat %+v", message)
+	}
+	if findMessage(biaConn, "chat", &message) {
+		t.Fatal("room chat reached another instance")
+	}
+
+	if err := s.chat(ana, DEFAULT_ROOM, ChatData{Text: "gg", Global: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !findMessage(biaConn, "chat", &message) || message.Text != "gg" || message.Room != DEFAULT_ROOM || message.From != "ana" {
+		t.Fatalf("global chat on the other instance %+v", message)
+	}
+
+	bp.Close()
+	if err := s.chat(ana, DEFAULT_ROOM, ChatData{Text: "alô?", Global: true}); err == nil || err.Code != ERR_UNAVAILABLE {
+		t.Fatalf("got %v, want %s without a backplane", err, ERR_UNAVAILABLE)
+	}
+}
+
+func TestGlobalLeaderboard(t *testing.T) {
+	bp := backplane.NewMemory()
+	one, oneRooms := newTestInstance(t, bp, "one", DEFAULT_ROOM)
+	two, twoRooms := newTestInstance(t, bp, "two", "duel")
+	_, ana := joinRoom(t, oneRooms, DEFAULT_ROOM, "ana", "A")
+	biaConn, bia := joinRoom(t, twoRooms, "duel", "bia", "B")
+	ana.Kills = 2
+	bia.Kills = 5
+
+	one.announce()
+	two.announce()
+
+	var sent []GlobalLeaderboardEntry
+	if !findMessage(biaConn, "globalLeaderboard", &sent) {
+		t.Fatal("no global leaderboard sent")
+	}
+	board := one.board
+	if len(board) != 2 || board[0].Name != "bia" || board[0].Room != "duel" || board[0].Rank != 1 || board[1].Name != "ana" {
+		t.Fatalf("global leaderboard %+v, want bia then ana", board)
+	}
+
+	conn, _ := joinRoom(t, twoRooms, "duel", "caio", "C")
+	var welcome WelcomeData
+	if !findMessage(conn, "welcome", &welcome) || len(welcome.GlobalLeaderboard) != 2 {
+		t.Fatalf("welcome leaderboard %+v", welcome.GlobalLeaderboard)
+	}
+}
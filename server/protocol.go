@@ -0,0 +1,430 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+
+	"multiplayer-game/engine"
+)
+
+const (
+	PROTOCOL_VERSION = 3
+	// Binary frames carry no walls, and clients before version 3 cannot
+	// draw them from the map, so they are no longer accepted.
+	MIN_PROTOCOL_VERSION = 3
+
+	MAX_CREDENTIAL_LENGTH = 4096
+	MAX_CHAT_LENGTH       = 200
+)
+
+const (
+	CAPABILITY_BATCH      = "batch"
+	CAPABILITY_PREDICTION = "prediction"
+)
+
+var serverCapabilities = []string{CAPABILITY_BINARY, CAPABILITY_BATCH, CAPABILITY_PREDICTION}
+
+const (
+	ERR_BAD_JSON            = "bad_json"
+	ERR_UNKNOWN_TYPE        = "unknown_type"
+	ERR_INVALID_PAYLOAD     = "invalid_payload"
+	ERR_HANDSHAKE_REQUIRED  = "handshake_required"
+	ERR_UNSUPPORTED_VERSION = "unsupported_version"
+	ERR_ALREADY_JOINED      = "already_joined"
+	ERR_RATE_LIMITED        = "rate_limited"
+	ERR_AUTH_FAILED         = "auth_failed"
+	ERR_UNAVAILABLE         = "unavailable"
+)
+
+type session struct {
+	version      int
+	capabilities map[string]bool
+}
+
+type Message struct {
+	Type string      `json:"type"`
+	ID   string      `json:"id,omitempty"`
+	Data interface{} `json:"data"`
+}
+
+type InboundMessage struct {
+	Type string          `json:"type"`
+	ID   string          `json:"id,omitempty" desc:"Optional request ID echoed back in ack/nack"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+type HelloData struct {
+	Version      int      `json:"version" desc:"Highest protocol version the client speaks"`
+	MinVersion   int      `json:"minVersion,omitempty" desc:"Lowest protocol version the client accepts"`
+	Capabilities []string `json:"capabilities,omitempty" desc:"Optional features the client supports"`
+}
+
+type JoinData struct {
+	Name      string        `json:"name"`
+	Character string        `json:"character"`
+	Spectator bool          `json:"spectator"`
+	Viewport  *ViewportData `json:"viewport,omitempty" desc:"Requested viewport size in cells; the server clamps it"`
+
+	ReclaimToken string `json:"reclaimToken,omitempty" desc:"Token from an earlier welcome; after a server restart it resumes that player instead of joining a new one"`
+
+	Password string `json:"password,omitempty" desc:"Arena or account password, when the server asks for one"`
+	Token    string `json:"token,omitempty" desc:"Login token from the portal, when the server asks for one"`
+}
+
+type MoveData struct {
+	Direction string `json:"direction"`
+	Seq       uint32 `json:"seq,omitempty" desc:"Client input sequence number, echoed as lastSeq in snapshots"`
+}
+
+type ShootData struct {
+	Direction string `json:"direction"`
+}
+
+type ReloadData struct{}
+
+type ViewportData struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+type HelloReplyData struct {
+	Version      int      `json:"version" desc:"Negotiated protocol version"`
+	Capabilities []string `json:"capabilities" desc:"Capabilities enabled for this connection"`
+}
+
+type WelcomeData struct {
+	PlayerID    string                    `json:"playerId"`
+	World       string                    `json:"world" desc:"ASCII rendering of the client's viewport"`
+	Players     []engine.PlayerListEntry  `json:"players"`
+	Leaderboard []engine.LeaderboardEntry `json:"leaderboard"`
+	Map         engine.MapData            `json:"map" desc:"Static map layout, needed to draw walls from binary frames"`
+	Items       []engine.ItemKind         `json:"items" desc:"Every item that can lie on the map and the glyph it is drawn with"`
+	Ammo        *engine.AmmoState         `json:"ammo,omitempty" desc:"The player's weapon and ammo; absent for spectators"`
+
+	ReclaimToken string `json:"reclaimToken" desc:"Secret to send in join to resume this player after a server restart"`
+	Reclaimed    bool   `json:"reclaimed,omitempty" desc:"The join resumed a player saved before a restart"`
+
+	GlobalLeaderboard []GlobalLeaderboardEntry `json:"globalLeaderboard,omitempty" desc:"Best players across every room on every server, once known"`
+}
+
+type SnapshotData struct {
+	Tick     uint64               `json:"tick" desc:"Server tick that produced this state"`
+	LastSeq  uint32               `json:"lastSeq" desc:"Sequence number of the last move the server applied for this client"`
+	Width    int                  `json:"width" desc:"World width"`
+	Height   int                  `json:"height" desc:"World height"`
+	Viewport engine.Viewport      `json:"viewport" desc:"Part of the world rendered in the accompanying worldUpdate"`
+	Players  []engine.EntityState `json:"players" desc:"Authoritative positions of the client and of everyone inside its viewport"`
+}
+
+type AckData struct {
+	RequestType string `json:"requestType"`
+}
+
+type IdleData struct {
+	Action         string `json:"action" desc:"spectate or kick"`
+	TimeoutSeconds int    `json:"timeoutSeconds"`
+}
+
+type ConfigChangedData struct {
+	Changed         []string        `json:"changed" desc:"Names of the settings that changed, as in the server config file"`
+	WorldWidth      int             `json:"worldWidth"`
+	WorldHeight     int             `json:"worldHeight"`
+	ShootCooldownMs int             `json:"shootCooldownMs"`
+	RespawnTimeMs   int             `json:"respawnTimeMs"`
+	FogOfWar        bool            `json:"fogOfWar"`
+	Map             *engine.MapData `json:"map,omitempty" desc:"The new map, when the change started a new round and everyone respawned"`
+}
+
+type ChatData struct {
+	Text   string `json:"text"`
+	Global bool   `json:"global,omitempty" desc:"Send to every room on every server instead of only this room"`
+}
+
+type ChatMessage struct {
+	From      string `json:"from"`
+	Character string `json:"character"`
+	Room      string `json:"room"`
+	Text      string `json:"text"`
+	Global    bool   `json:"global,omitempty"`
+}
+
+type GlobalLeaderboardEntry struct {
+	Rank      int    `json:"rank"`
+	Name      string `json:"name"`
+	Character string `json:"character"`
+	Room      string `json:"room"`
+	Kills     int    `json:"kills"`
+	Deaths    int    `json:"deaths"`
+	KDR       string `json:"kdr" desc:"Kill/death ratio with two decimals"`
+}
+
+type ServerShutdownData struct {
+	Reason               string `json:"reason"`
+	Resumable            bool   `json:"resumable" desc:"The match was saved; reconnect and join with the reclaim token to resume it"`
+	ReclaimWindowSeconds int    `json:"reclaimWindowSeconds,omitempty" desc:"How long after the restart saved players can be reclaimed"`
+}
+
+type inboundPayload interface {
+	validate() *engine.GameError
+}
+
+type messageSpec struct {
+	Type        string
+	Description string
+	Payload     interface{}
+}
+
+var inboundMessages = []messageSpec{
+	{"hello", "Opens the session and negotiates the protocol version; must be the first message", HelloData{}},
+	{"join", "Enters the game as a player or spectator", JoinData{}},
+	{"move", "Moves the player one cell", MoveData{}},
+	{"shoot", "Fires a bullet", ShootData{}},
+	{"reload", "Starts reloading the player's weapon", ReloadData{}},
+	{"viewport", "Changes the viewport size in cells; the server clamps it", ViewportData{}},
+	{"chat", "Says something to the room or, when global, to everyone", ChatData{}},
+}
+
+var outboundMessages = []messageSpec{
+	{"hello", "Handshake reply with the negotiated version and capabilities", HelloReplyData{}},
+	{"welcome", "Sent once after a successful join", WelcomeData{}},
+	{"worldUpdate", "ASCII rendering of the client's viewport; clients with the binary capability receive binary frames instead", ""},
+	{"playerList", "Everyone currently connected", []engine.PlayerListEntry{}},
+	{"leaderboard", "Players ranked by kills, then deaths", []engine.LeaderboardEntry{}},
+	{"snapshot", "Authoritative player positions, sent with every world update to clients with the prediction capability", SnapshotData{}},
+	{"ammo", "The player's weapon and ammo; sent when either changes", engine.AmmoState{}},
+	{"idle", "The player was inactive for too long and was moved to spectators or is about to be disconnected", IdleData{}},
+	{"configChanged", "The server's settings were reloaded; sent on the tick they take effect", ConfigChangedData{}},
+	{"serverShutdown", "The server is going down and will close the connection", ServerShutdownData{}},
+	{"chat", "A player said something in this room or, when global, anywhere", ChatMessage{}},
+	{"globalLeaderboard", "Best players across every room on every server; sent when it changes", []GlobalLeaderboardEntry{}},
+	{"batch", "Several messages produced in the same update, delivered in order in one frame", []Message{}},
+	{"joinRejected", "The join request was refused", engine.GameError{}},
+	{"ack", "A request carrying an id succeeded", AckData{}},
+	{"nack", "A request carrying an id failed", engine.GameError{}},
+	{"error", "A request without an id failed, or the message could not be parsed", engine.GameError{}},
+}
+
+var validDirections = map[string]bool{"up": true, "down": true, "left": true, "right": true}
+
+func (h *HelloData) validate() *engine.GameError {
+	if h.Version < 1 {
+		return &engine.GameError{Code: ERR_INVALID_PAYLOAD, Reason: "version must be a positive integer"}
+	}
+	if h.MinVersion > h.Version {
+		return &engine.GameError{Code: ERR_INVALID_PAYLOAD, Reason: "minVersion must not exceed version"}
+	}
+	return nil
+}
+
+func (j *JoinData) validate() *engine.GameError {
+	if j.Name == "" {
+		return &engine.GameError{Code: ERR_INVALID_PAYLOAD, Reason: "name is required"}
+	}
+	if len(j.ReclaimToken) > 2*engine.RECLAIM_TOKEN_BYTES {
+		return &engine.GameError{Code: ERR_INVALID_PAYLOAD, Reason: "reclaimToken is too long"}
+	}
+	if len(j.Password) > MAX_CREDENTIAL_LENGTH || len(j.Token) > MAX_CREDENTIAL_LENGTH {
+		return &engine.GameError{Code: ERR_INVALID_PAYLOAD, Reason: fmt.Sprintf("password and token must be at most %d bytes", MAX_CREDENTIAL_LENGTH)}
+	}
+	return nil
+}
+
+func (m *MoveData) validate() *engine.GameError {
+	if !validDirections[m.Direction] {
+		return &engine.GameError{Code: engine.ERR_INVALID_DIRECTION, Reason: fmt.Sprintf("unknown direction %q", m.Direction)}
+	}
+	return nil
+}
+
+func (v *ViewportData) validate() *engine.GameError {
+	if v.Width <= 0 || v.Height <= 0 {
+		return &engine.GameError{Code: ERR_INVALID_PAYLOAD, Reason: "viewport width and height must be positive"}
+	}
+	return nil
+}
+
+func (s *ShootData) validate() *engine.GameError {
+	if !validDirections[s.Direction] {
+		return &engine.GameError{Code: engine.ERR_INVALID_DIRECTION, Reason: fmt.Sprintf("unknown direction %q", s.Direction)}
+	}
+	return nil
+}
+
+func (r *ReloadData) validate() *engine.GameError {
+	return nil
+}
+
+func (c *ChatData) validate() *engine.GameError {
+	if cleanChat(c.Text) == "" {
+		return &engine.GameError{Code: ERR_INVALID_PAYLOAD, Reason: "text is required"}
+	}
+	if utf8.RuneCountInString(c.Text) > MAX_CHAT_LENGTH {
+		return &engine.GameError{Code: ERR_INVALID_PAYLOAD, Reason: fmt.Sprintf("text must be at most %d characters", MAX_CHAT_LENGTH)}
+	}
+	return nil
+}
+
+func strictUnmarshal(raw []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if decoder.More() {
+		return fmt.Errorf("unexpected data after JSON value")
+	}
+	return nil
+}
+
+func decodeMessage(raw []byte) (InboundMessage, inboundPayload, *engine.GameError) {
+	var msg InboundMessage
+	if err := strictUnmarshal(raw, &msg); err != nil {
+		return msg, nil, &engine.GameError{Code: ERR_BAD_JSON, Reason: fmt.Sprintf("message is not valid JSON: %v", err)}
+	}
+
+	var spec *messageSpec
+	for i := range inboundMessages {
+		if inboundMessages[i].Type == msg.Type {
+			spec = &inboundMessages[i]
+			break
+		}
+	}
+	if spec == nil {
+		return msg, nil, &engine.GameError{Code: ERR_UNKNOWN_TYPE, Reason: fmt.Sprintf("unknown message type %q", msg.Type)}
+	}
+
+	if len(msg.Data) == 0 || string(msg.Data) == "null" {
+		return msg, nil, &engine.GameError{Code: ERR_INVALID_PAYLOAD, Reason: fmt.Sprintf("%s requires a data object", msg.Type)}
+	}
+
+	payload := reflect.New(reflect.TypeOf(spec.Payload)).Interface().(inboundPayload)
+	if err := strictUnmarshal(msg.Data, payload); err != nil {
+		return msg, nil, &engine.GameError{Code: ERR_INVALID_PAYLOAD, Reason: fmt.Sprintf("invalid %s data: %v", msg.Type, err)}
+	}
+
+	if err := payload.validate(); err != nil {
+		return msg, nil, err
+	}
+
+	return msg, payload, nil
+}
+
+func negotiate(hello *HelloData) (*session, []string, *engine.GameError) {
+	version := hello.Version
+	if version > PROTOCOL_VERSION {
+		version = PROTOCOL_VERSION
+	}
+
+	if version < MIN_PROTOCOL_VERSION || version < hello.MinVersion {
+		return nil, nil, &engine.GameError{
+			Code:   ERR_UNSUPPORTED_VERSION,
+			Reason: fmt.Sprintf("server supports protocol versions %d-%d", MIN_PROTOCOL_VERSION, PROTOCOL_VERSION),
+		}
+	}
+
+	sess := &session{version: version, capabilities: make(map[string]bool)}
+	enabled := []string{}
+	for _, capability := range serverCapabilities {
+		for _, requested := range hello.Capabilities {
+			if capability == requested {
+				sess.capabilities[capability] = true
+				enabled = append(enabled, capability)
+				break
+			}
+		}
+	}
+
+	return sess, enabled, nil
+}
+
+func schemaFor(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaFor(t.Elem(), defs)
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem(), defs)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaFor(t.Elem(), defs)}
+	case reflect.Struct:
+		if _, exists := defs[t.Name()]; !exists {
+			defs[t.Name()] = nil
+			properties := map[string]interface{}{}
+			required := []string{}
+			for i := 0; i < t.NumField(); i++ {
+				field := t.Field(i)
+				name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+				if !field.IsExported() || name == "-" {
+					continue
+				}
+				if name == "" {
+					name = field.Name
+				}
+
+				property := schemaFor(field.Type, defs)
+				if desc := field.Tag.Get("desc"); desc != "" {
+					property = map[string]interface{}{"allOf": []interface{}{property}, "description": desc}
+				}
+				properties[name] = property
+
+				if !strings.Contains(options, "omitempty") {
+					required = append(required, name)
+				}
+			}
+			defs[t.Name()] = map[string]interface{}{
+				"type":                 "object",
+				"properties":           properties,
+				"required":             required,
+				"additionalProperties": false,
+			}
+		}
+		return map[string]interface{}{"$ref": "#/$defs/" + t.Name()}
+	}
+	return map[string]interface{}{}
+}
+
+func envelopeSchemas(specs []messageSpec, defs map[string]interface{}) []interface{} {
+	envelopes := make([]interface{}, 0, len(specs))
+	for _, spec := range specs {
+		envelopes = append(envelopes, map[string]interface{}{
+			"description": spec.Description,
+			"type":        "object",
+			"properties": map[string]interface{}{
+				"type": map[string]interface{}{"const": spec.Type},
+				"id":   map[string]interface{}{"type": "string"},
+				"data": schemaFor(reflect.TypeOf(spec.Payload), defs),
+			},
+			"required":             []string{"type", "data"},
+			"additionalProperties": false,
+		})
+	}
+	return envelopes
+}
+
+func ProtocolSchema() ([]byte, error) {
+	defs := map[string]interface{}{}
+	schema := map[string]interface{}{
+		"$schema":        "https://json-schema.org/draft/2020-12/schema",
+		"title":          "ASCII battle arena wire protocol",
+		"description":    "Every message is a JSON text frame of the form {type, id?, data}",
+		"version":        PROTOCOL_VERSION,
+		"minVersion":     MIN_PROTOCOL_VERSION,
+		"capabilities":   serverCapabilities,
+		"$defs":          defs,
+		"clientToServer": map[string]interface{}{"oneOf": envelopeSchemas(inboundMessages, defs)},
+		"serverToClient": map[string]interface{}{"oneOf": envelopeSchemas(outboundMessages, defs)},
+	}
+	return json.MarshalIndent(schema, "", "  ")
+}
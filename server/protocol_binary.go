@@ -0,0 +1,138 @@
+package server
+
+import (
+	"encoding/binary"
+	"sort"
+
+	"multiplayer-game/engine"
+)
+
+const (
+	CAPABILITY_BINARY = "binary"
+
+	FRAME_ENTITIES byte = 1
+	FRAME_DELTA    byte = 2
+
+	ENTITY_FLAG_PROTECTED byte = 1
+)
+
+func appendFrameHeader(frame []byte, kind byte, view engine.Viewport) []byte {
+	frame = append(frame, kind)
+	frame = binary.AppendUvarint(frame, uint64(view.Width))
+	frame = binary.AppendUvarint(frame, uint64(view.Height))
+	frame = binary.AppendUvarint(frame, uint64(view.X))
+	frame = binary.AppendUvarint(frame, uint64(view.Y))
+	return frame
+}
+
+// encodeEntityFrame packs the entities inside a viewport as
+//
+//	kind | width | height | originX | originY | players | bullets | items
+//
+// where every number is a uvarint, players are (cell gap, character, flags),
+// bullets are cell gaps and items are (cell gap, glyph). Cells are relative to the viewport origin
+// (y*width+x), sorted, and each one is stored as the distance from the
+// previous cell so dense areas stay small.
+func encodeEntityFrame(view engine.Viewport, entities []engine.EntityState, bullets []engine.Bullet, items []engine.Item) []byte {
+	playerCells := make([]int, 0, len(entities))
+	characters := make(map[int]byte, len(entities))
+	flags := make(map[int]byte, len(entities))
+	for _, entity := range entities {
+		if entity.Dead || entity.Character == "" || !view.Contains(entity.X, entity.Y) {
+			continue
+		}
+		cell := (entity.Y-view.Y)*view.Width + entity.X - view.X
+		playerCells = append(playerCells, cell)
+		characters[cell] = entity.Character[0]
+		if entity.Protected {
+			flags[cell] |= ENTITY_FLAG_PROTECTED
+		}
+	}
+	sort.Ints(playerCells)
+
+	bulletCells := make([]int, 0, len(bullets))
+	for _, bullet := range bullets {
+		if view.Contains(bullet.X, bullet.Y) {
+			bulletCells = append(bulletCells, (bullet.Y-view.Y)*view.Width+bullet.X-view.X)
+		}
+	}
+	sort.Ints(bulletCells)
+
+	itemCells := make([]int, 0, len(items))
+	glyphs := make(map[int]byte, len(items))
+	for _, item := range items {
+		if view.Contains(item.X, item.Y) {
+			cell := (item.Y-view.Y)*view.Width + item.X - view.X
+			itemCells = append(itemCells, cell)
+			glyphs[cell] = item.Glyph()
+		}
+	}
+	sort.Ints(itemCells)
+
+	frame := make([]byte, 0, 16+len(playerCells)*4+len(bulletCells)*2+len(itemCells)*3)
+	frame = appendFrameHeader(frame, FRAME_ENTITIES, view)
+
+	frame = binary.AppendUvarint(frame, uint64(len(playerCells)))
+	previous := 0
+	for _, cell := range playerCells {
+		frame = binary.AppendUvarint(frame, uint64(cell-previous))
+		frame = append(frame, characters[cell], flags[cell])
+		previous = cell
+	}
+
+	frame = binary.AppendUvarint(frame, uint64(len(bulletCells)))
+	previous = 0
+	for _, cell := range bulletCells {
+		frame = binary.AppendUvarint(frame, uint64(cell-previous))
+		previous = cell
+	}
+
+	frame = binary.AppendUvarint(frame, uint64(len(itemCells)))
+	previous = 0
+	for _, cell := range itemCells {
+		frame = binary.AppendUvarint(frame, uint64(cell-previous))
+		frame = append(frame, glyphs[cell])
+		previous = cell
+	}
+
+	return frame
+}
+
+// encodeDeltaFrame packs the viewport cells that changed since previous as
+//
+//	kind | width | height | originX | originY | bitmap | characters
+//
+// with one bit per cell (row-major, least significant bit first) followed by
+// the new character of every set bit in order.
+func encodeDeltaFrame(view engine.Viewport, previous, current []byte) []byte {
+	bitmap := make([]byte, (len(current)+7)/8)
+	changed := make([]byte, 0, 64)
+	for i := range current {
+		if previous[i] != current[i] {
+			bitmap[i/8] |= 1 << (i % 8)
+			changed = append(changed, current[i])
+		}
+	}
+
+	frame := make([]byte, 0, 16+len(bitmap)+len(changed))
+	frame = appendFrameHeader(frame, FRAME_DELTA, view)
+	frame = append(frame, bitmap...)
+	frame = append(frame, changed...)
+
+	return frame
+}
+
+// worldFrame picks the smaller of the entity frame and a delta against what
+// this client last received. A client without a previous frame of the same
+// size always gets the entity frame, which doubles as a keyframe.
+func worldFrame(view engine.Viewport, entities []byte, previous, current []byte) []byte {
+	if len(previous) != len(current) {
+		return entities
+	}
+
+	delta := encodeDeltaFrame(view, previous, current)
+	if len(delta) < len(entities) {
+		return delta
+	}
+	return entities
+}
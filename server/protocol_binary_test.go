@@ -0,0 +1,192 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"multiplayer-game/engine"
+)
+
+// decodedFrame is an entity frame read back following the layout in
+// PROTOCOL.md. Each cell is an absolute cell number in the viewport.
+type decodedFrame struct {
+	Kind          byte
+	Width, Height int
+	X, Y          int
+	Players       [][3]int // cell, character, flags
+	Bullets       []int
+	Items         [][2]int // cell, glyph
+}
+
+// frameReader reads uvarints and bytes from a frame, failing the test on
+// truncated input.
+type frameReader struct {
+	t     *testing.T
+	frame []byte
+}
+
+func (r *frameReader) uvarint() int {
+	r.t.Helper()
+	value, n := binary.Uvarint(r.frame)
+	if n <= 0 {
+		r.t.Fatalf("truncated uvarint in frame")
+	}
+	r.frame = r.frame[n:]
+	return int(value)
+}
+
+func (r *frameReader) byte() int {
+	r.t.Helper()
+	if len(r.frame) == 0 {
+		r.t.Fatalf("truncated frame")
+	}
+	b := r.frame[0]
+	r.frame = r.frame[1:]
+	return int(b)
+}
+
+func decodeEntityFrame(t *testing.T, frame []byte) decodedFrame {
+	t.Helper()
+	r := &frameReader{t: t, frame: frame}
+	decoded := decodedFrame{Kind: byte(r.byte()), Width: r.uvarint(), Height: r.uvarint(), X: r.uvarint(), Y: r.uvarint()}
+
+	cell := 0
+	for i, count := 0, r.uvarint(); i < count; i++ {
+		cell += r.uvarint()
+		decoded.Players = append(decoded.Players, [3]int{cell, r.byte(), r.byte()})
+	}
+	cell = 0
+	for i, count := 0, r.uvarint(); i < count; i++ {
+		cell += r.uvarint()
+		decoded.Bullets = append(decoded.Bullets, cell)
+	}
+	cell = 0
+	for i, count := 0, r.uvarint(); i < count; i++ {
+		cell += r.uvarint()
+		decoded.Items = append(decoded.Items, [2]int{cell, r.byte()})
+	}
+	if len(r.frame) != 0 {
+		t.Fatalf("%d bytes left after the frame", len(r.frame))
+	}
+	return decoded
+}
+
+func TestEncodeEntityFrame(t *testing.T) {
+	// Wide enough that cell gaps take more than one uvarint byte.
+	view := engine.Viewport{X: 150, Y: 300, Width: 200, Height: 10}
+	entities := []engine.EntityState{
+		{ID: "p2", Character: "B", X: 151, Y: 305, Protected: true},
+		{ID: "p1", Character: "A", X: 150, Y: 300},
+		{ID: "p3", Character: "C", X: 160, Y: 302, Dead: true},
+		{ID: "p4", Character: "D", X: 10, Y: 300},
+		{ID: "p5", Character: "", X: 152, Y: 300},
+	}
+	bullets := []engine.Bullet{{ID: "bullet_2", X: 349, Y: 309}, {ID: "bullet_1", X: 155, Y: 300}, {ID: "bullet_3", X: 350, Y: 300}}
+	items := []engine.Item{
+		{ID: "crate_2", Kind: engine.WEAPON_RIFLE, X: 152, Y: 301},
+		{ID: "powerup_1", Kind: engine.POWER_UP_SHIELD, X: 151, Y: 300},
+		{ID: "crate_3", Kind: engine.CRATE_AMMO, X: 149, Y: 300},
+	}
+
+	want := decodedFrame{
+		Kind:    FRAME_ENTITIES,
+		Width:   200,
+		Height:  10,
+		X:       150,
+		Y:       300,
+		Players: [][3]int{{0, 'A', 0}, {5*200 + 1, 'B', int(ENTITY_FLAG_PROTECTED)}},
+		Bullets: []int{5, 9*200 + 199},
+		Items:   [][2]int{{1, '['}, {200 + 2, '}'}},
+	}
+	if got := decodeEntityFrame(t, encodeEntityFrame(view, entities, bullets, items)); !reflect.DeepEqual(got, want) {
+		t.Fatalf("decoded %+v, want %+v", got, want)
+	}
+}
+
+func TestEncodeDeltaFrame(t *testing.T) {
+	view := engine.Viewport{X: 3, Y: 4, Width: 5, Height: 2}
+	previous := []byte("A *  " + "   ~ ")
+	current := []byte("  *B " + "   ~*")
+
+	frame := encodeDeltaFrame(view, previous, current)
+	// Cells 0 (A left), 3 (B arrived) and 9 (a bullet arrived) changed:
+	// bits 0 and 3 of the first byte, bit 1 of the second.
+	want := []byte{FRAME_DELTA, 5, 2, 3, 4, 0b00001001, 0b00000010, ' ', 'B', '*'}
+	if !bytes.Equal(frame, want) {
+		t.Fatalf("frame %v, want %v", frame, want)
+	}
+}
+
+func benchmarkWorld(playerCount, bulletCount int) (engine.Viewport, []engine.EntityState, []engine.Bullet) {
+	view := engine.Viewport{X: 40, Y: 20, Width: engine.DEFAULT_VIEWPORT_WIDTH, Height: engine.DEFAULT_VIEWPORT_HEIGHT}
+
+	entities := make([]engine.EntityState, 0, playerCount)
+	for i := 0; i < playerCount; i++ {
+		entities = append(entities, engine.EntityState{
+			ID:        fmt.Sprintf("p%d", i),
+			X:         view.X + (i*37)%view.Width,
+			Y:         view.Y + (i*11)%view.Height,
+			Character: string(rune('A' + i%26)),
+		})
+	}
+
+	bullets := make([]engine.Bullet, 0, bulletCount)
+	for i := 0; i < bulletCount; i++ {
+		bullets = append(bullets, engine.Bullet{
+			ID:   fmt.Sprintf("bullet_%d", i),
+			X:    view.X + (i*53)%view.Width,
+			Y:    view.Y + (i*7)%view.Height,
+			DirX: 1,
+		})
+	}
+
+	return view, entities, bullets
+}
+
+func BenchmarkWorldUpdateJSON(b *testing.B) {
+	world := engine.NewGameWorld(engine.DefaultConfig())
+	view, entities, bullets := benchmarkWorld(16, 32)
+	b.ReportAllocs()
+
+	var size int
+	for i := 0; i < b.N; i++ {
+		text := engine.RenderText(world.RenderViewport(view, entities, bullets, nil), view.Width, view.Height)
+		payload, err := json.Marshal(Message{Type: "worldUpdate", Data: text})
+		if err != nil {
+			b.Fatal(err)
+		}
+		size = len(payload)
+	}
+	b.ReportMetric(float64(size), "bytes/frame")
+}
+
+func BenchmarkWorldUpdateBinaryEntities(b *testing.B) {
+	view, entities, bullets := benchmarkWorld(16, 32)
+	b.ReportAllocs()
+
+	var size int
+	for i := 0; i < b.N; i++ {
+		size = len(encodeEntityFrame(view, entities, bullets, nil))
+	}
+	b.ReportMetric(float64(size), "bytes/frame")
+}
+
+func BenchmarkWorldUpdateBinaryDelta(b *testing.B) {
+	world := engine.NewGameWorld(engine.DefaultConfig())
+	view, entities, bullets := benchmarkWorld(16, 32)
+	previous := world.RenderViewport(view, entities, bullets, nil)
+	for i := range bullets {
+		bullets[i].X++
+	}
+	b.ReportAllocs()
+
+	var size int
+	for i := 0; i < b.N; i++ {
+		size = len(encodeDeltaFrame(view, previous, world.RenderViewport(view, entities, bullets, nil)))
+	}
+	b.ReportMetric(float64(size), "bytes/frame")
+}
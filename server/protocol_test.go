@@ -0,0 +1,87 @@
+package server
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"multiplayer-game/engine"
+)
+
+func TestDecodeMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		wantType string
+		wantCode string
+	}{
+		{name: "move", raw: `{"type":"move","data":{"direction":"up","seq":3}}`, wantType: "move"},
+		{name: "shoot with id", raw: `{"type":"shoot","id":"s1","data":{"direction":"left"}}`, wantType: "shoot"},
+		{name: "not json", raw: `{"type":`, wantCode: ERR_BAD_JSON},
+		{name: "unknown type", raw: `{"type":"teleport","data":{}}`, wantCode: ERR_UNKNOWN_TYPE},
+		{name: "unknown field", raw: `{"type":"move","data":{"direction":"up","speed":9}}`, wantCode: ERR_INVALID_PAYLOAD},
+		{name: "bad direction", raw: `{"type":"move","data":{"direction":"north"}}`, wantCode: engine.ERR_INVALID_DIRECTION},
+		{name: "global chat", raw: `{"type":"chat","data":{"text":"gg","global":true}}`, wantType: "chat"},
+		{name: "blank chat", raw: `{"type":"chat","data":{"text":" \u0007 "}}`, wantCode: ERR_INVALID_PAYLOAD},
+		{name: "reload", raw: `{"type":"reload","id":"r1","data":{}}`, wantType: "reload"},
+		{name: "reload with data", raw: `{"type":"reload","data":{"weapon":"rifle"}}`, wantCode: ERR_INVALID_PAYLOAD},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, payload, err := decodeMessage([]byte(tt.raw))
+			if got := errorCode(err); got != tt.wantCode {
+				t.Fatalf("error code = %q, want %q (%v)", got, tt.wantCode, err)
+			}
+			if err == nil && (msg.Type != tt.wantType || payload == nil) {
+				t.Fatalf("decoded type %q with payload %v, want %q", msg.Type, payload, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name        string
+		hello       HelloData
+		wantVersion int
+		wantCaps    int
+		wantCode    string
+	}{
+		{name: "current", hello: HelloData{Version: PROTOCOL_VERSION, Capabilities: []string{CAPABILITY_BINARY, "telepathy"}}, wantVersion: PROTOCOL_VERSION, wantCaps: 1},
+		{name: "newer client", hello: HelloData{Version: PROTOCOL_VERSION + 5}, wantVersion: PROTOCOL_VERSION},
+		{name: "oldest", hello: HelloData{Version: MIN_PROTOCOL_VERSION}, wantVersion: MIN_PROTOCOL_VERSION},
+		{name: "too old", hello: HelloData{Version: MIN_PROTOCOL_VERSION - 1}, wantCode: ERR_UNSUPPORTED_VERSION},
+		{name: "min above server", hello: HelloData{Version: PROTOCOL_VERSION + 1, MinVersion: PROTOCOL_VERSION + 1}, wantCode: ERR_UNSUPPORTED_VERSION},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sess, enabled, err := negotiate(&tt.hello)
+			if got := errorCode(err); got != tt.wantCode {
+				t.Fatalf("error code = %q, want %q", got, tt.wantCode)
+			}
+			if err != nil {
+				return
+			}
+			if sess.version != tt.wantVersion || len(enabled) != tt.wantCaps {
+				t.Fatalf("version %d with %v, want %d with %d capabilities", sess.version, enabled, tt.wantVersion, tt.wantCaps)
+			}
+		})
+	}
+}
+
+func TestSchemaUpToDate(t *testing.T) {
+	committed, err := os.ReadFile("../protocol.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	generated, err := ProtocolSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(bytes.TrimSpace(committed), bytes.TrimSpace(generated)) {
+		t.Fatal("protocol.schema.json is out of date; run go generate ./...")
+	}
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"multiplayer-game/engine"
+)
+
+func TestAdminReload(t *testing.T) {
+	var fail bool
+	reloads := 0
+	h := AdminHandler("secret", func() (map[string][]string, error) {
+		reloads++
+		if fail {
+			return nil, errors.New("bad config")
+		}
+		return map[string][]string{"main": {"shootCooldown"}}, nil
+	})
+
+	tests := []struct {
+		name       string
+		method     string
+		auth       string
+		fail       bool
+		wantStatus int
+	}{
+		{name: "no token", method: http.MethodPost, wantStatus: http.StatusUnauthorized},
+		{name: "wrong token", method: http.MethodPost, auth: "Bearer guess", wantStatus: http.StatusUnauthorized},
+		{name: "wrong method", method: http.MethodGet, auth: "Bearer secret", wantStatus: http.StatusMethodNotAllowed},
+		{name: "reload", method: http.MethodPost, auth: "Bearer secret", wantStatus: http.StatusOK},
+		{name: "invalid config", method: http.MethodPost, auth: "Bearer secret", fail: true, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fail = tt.fail
+			req := httptest.NewRequest(tt.method, "/admin/reload", nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus == http.StatusOK {
+				var reply ReloadReply
+				if err := json.Unmarshal(rec.Body.Bytes(), &reply); err != nil || len(reply.Rooms["main"]) != 1 {
+					t.Fatalf("reply %s (%v)", rec.Body, err)
+				}
+			}
+		})
+	}
+	if reloads != 2 {
+		t.Fatalf("reload called %d times, want 2", reloads)
+	}
+
+	disabled := AdminHandler("", func() (map[string][]string, error) { return nil, nil })
+	req := httptest.NewRequest(http.MethodPost, "/admin/reload", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	rec := httptest.NewRecorder()
+	disabled.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("admin endpoint without a token returned %d", rec.Code)
+	}
+}
+
+func TestRoomsReconfigure(t *testing.T) {
+	newRoom := func() *Server {
+		return NewServer(engine.NewGame(engine.DefaultConfig(), engine.NewManualClock(time.Time{}), rand.New(rand.NewSource(1))))
+	}
+	rooms := NewRooms(map[string]*Server{"main": newRoom(), "duel": newRoom()})
+
+	valid := engine.DefaultConfig()
+	valid.ShootCooldown = time.Second
+	invalid := engine.DefaultConfig()
+	invalid.VisionRadius = 0
+
+	if _, err := rooms.Reconfigure(map[string]engine.Config{"main": valid, "duel": invalid}); err == nil {
+		t.Fatal("invalid room config accepted")
+	}
+	if changes, _ := rooms.Reconfigure(map[string]engine.Config{"main": engine.DefaultConfig()}); len(changes) != 0 {
+		t.Fatalf("nothing should be pending after a rejected reload, got %v", changes)
+	}
+
+	changes, err := rooms.Reconfigure(map[string]engine.Config{"main": valid, "duel": engine.DefaultConfig(), "new": valid})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || len(changes["main"]) != 1 || changes["main"][0] != "shootCooldown" {
+		t.Fatalf("changes = %v", changes)
+	}
+	if _, ok := rooms.Get("new"); ok {
+		t.Fatal("reload added a room")
+	}
+}
+
+func TestConfigChangeIsAnnounced(t *testing.T) {
+	clock := engine.NewManualClock(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	s := NewServer(engine.NewGame(engine.DefaultConfig(), clock, rand.New(rand.NewSource(1))))
+	conn := &fakeConn{}
+	sess := &session{version: PROTOCOL_VERSION, capabilities: map[string]bool{}}
+	if _, err := s.addClient(conn, sess, JoinData{Name: "ana", Character: "A"}); err != nil {
+		t.Fatal(err)
+	}
+
+	config := engine.DefaultConfig()
+	config.MapSeed = 7
+	if _, err := s.game.Reconfigure(config); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(engine.TICK_INTERVAL)
+	s.tick()
+
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	for _, raw := range conn.messages {
+		var msg struct {
+			Type string            `json:"type"`
+			Data ConfigChangedData `json:"data"`
+		}
+		if json.Unmarshal(raw, &msg) != nil || msg.Type != "configChanged" {
+			continue
+		}
+		if len(msg.Data.Changed) != 1 || msg.Data.Changed[0] != "mapSeed" || msg.Data.Map == nil {
+			t.Fatalf("configChanged = %+v", msg.Data)
+		}
+		return
+	}
+	t.Fatal("no configChanged message sent")
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+
+	"multiplayer-game/auth"
+	"multiplayer-game/engine"
+)
+
+const DEFAULT_ROOM = "main"
+
+// Rooms routes WebSocket connections to one of several independent games,
+// picked by the room query parameter.
+type Rooms struct {
+	servers map[string]*Server
+}
+
+// NewRooms serves the given rooms. They share one login guard, so failed
+// logins count against an IP whichever room it tries.
+func NewRooms(servers map[string]*Server) *Rooms {
+	logins := newLoginGuard()
+	for _, s := range servers {
+		s.logins = logins
+	}
+	return &Rooms{servers: servers}
+}
+
+func (rs *Rooms) Get(name string) (*Server, bool) {
+	if name == "" {
+		name = DEFAULT_ROOM
+	}
+	s, ok := rs.servers[name]
+	return s, ok
+}
+
+// Names returns the room names in order.
+func (rs *Rooms) Names() []string {
+	names := make([]string, 0, len(rs.servers))
+	for name := range rs.servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AllowOrigins lets WebSocket connections in from the given origins besides
+// the server's own. Call it before serving.
+func (rs *Rooms) AllowOrigins(origins []string) {
+	checkOrigin := OriginChecker(origins)
+	for _, s := range rs.servers {
+		s.upgrader.CheckOrigin = checkOrigin
+	}
+}
+
+// SetAuthenticator makes every room check joins with a.
+func (rs *Rooms) SetAuthenticator(a auth.Authenticator) {
+	for _, s := range rs.servers {
+		s.SetAuthenticator(a)
+	}
+}
+
+// Run ticks every room until Shutdown.
+func (rs *Rooms) Run() {
+	for _, s := range rs.servers {
+		go s.Run()
+	}
+}
+
+// Reconfigure hands each room its new config, to take effect on the room's
+// next tick, and returns the knobs that will change per room. Nothing is
+// applied unless every config is valid. Rooms can only be added or removed
+// by a restart, so configs for unknown rooms are skipped.
+func (rs *Rooms) Reconfigure(configs map[string]engine.Config) (map[string][]string, error) {
+	for name, config := range configs {
+		if err := config.Validate(); err != nil {
+			return nil, fmt.Errorf("room %q: %v", name, err)
+		}
+	}
+
+	changes := make(map[string][]string)
+	for name, config := range configs {
+		s, ok := rs.servers[name]
+		if !ok {
+			log.Printf("Room %s needs a restart to be added", name)
+			continue
+		}
+		changed, err := s.game.Reconfigure(config)
+		if err != nil {
+			return changes, fmt.Errorf("room %q: %v", name, err)
+		}
+		if len(changed) > 0 {
+			changes[name] = changed
+		}
+	}
+	for name := range rs.servers {
+		if _, ok := configs[name]; !ok {
+			log.Printf("Room %s needs a restart to be removed", name)
+		}
+	}
+	return changes, nil
+}
+
+func (rs *Rooms) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("room")
+	s, ok := rs.Get(name)
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown room %q", name), http.StatusNotFound)
+		return
+	}
+	s.HandleWebSocket(w, r)
+}
+
+// SetChatRelay makes every room send global chat through relay.
+func (rs *Rooms) SetChatRelay(relay func(ChatMessage) error) {
+	for _, s := range rs.servers {
+		s.SetChatRelay(relay)
+	}
+}
+
+// deliverChat shows a global chat message in every room.
+func (rs *Rooms) deliverChat(message ChatMessage) {
+	for _, s := range rs.servers {
+		s.broadcast(Message{Type: "chat", Data: message})
+	}
+}
+
+func (rs *Rooms) setGlobalLeaderboard(board []GlobalLeaderboardEntry) {
+	for _, s := range rs.servers {
+		s.setGlobalLeaderboard(board)
+	}
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DEV_CERT_VALIDITY = 7 * 24 * time.Hour
+	HSTS_MAX_AGE      = "max-age=31536000"
+)
+
+// OriginChecker allows WebSocket upgrades from the server's own origin, from
+// the origins in allowed, or from anywhere if allowed holds "*". Requests
+// without an Origin header don't come from a browser and are allowed.
+func OriginChecker(allowed []string) func(r *http.Request) bool {
+	origins := make(map[string]bool, len(allowed))
+	for _, origin := range allowed {
+		origins[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+	}
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" || origins["*"] || origins[strings.ToLower(origin)] {
+			return true
+		}
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+}
+
+// loginGuard counts failed logins per remote IP across connections. An IP
+// is forgotten once it has not failed for AUTH_LOCKOUT.
+type loginGuard struct {
+	mutex    sync.Mutex
+	failures map[string]*loginFailures
+}
+
+type loginFailures struct {
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+func newLoginGuard() *loginGuard {
+	return &loginGuard{failures: make(map[string]*loginFailures)}
+}
+
+// lockedOut returns how long ip has to wait before it may log in again.
+func (lg *loginGuard) lockedOut(ip string, now time.Time) time.Duration {
+	lg.mutex.Lock()
+	defer lg.mutex.Unlock()
+
+	if f, ok := lg.failures[ip]; ok {
+		return max(0, f.lockedUntil.Sub(now))
+	}
+	return 0
+}
+
+// fail records a failed login from ip and reports whether that locked it
+// out.
+func (lg *loginGuard) fail(ip string, now time.Time) bool {
+	lg.mutex.Lock()
+	defer lg.mutex.Unlock()
+
+	for key, f := range lg.failures {
+		if now.Sub(f.last) > AUTH_LOCKOUT {
+			delete(lg.failures, key)
+		}
+	}
+
+	f, ok := lg.failures[ip]
+	if !ok {
+		f = &loginFailures{}
+		lg.failures[ip] = f
+	}
+	f.count++
+	f.last = now
+	if f.count < MAX_AUTH_FAILURES {
+		return false
+	}
+	f.count = 0
+	f.lockedUntil = now.Add(AUTH_LOCKOUT)
+	return true
+}
+
+// remoteIP is the address r came from, without the port.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// SecureHeaders keeps pages from being framed or sniffed. With hsts, it also
+// tells browsers to only come back over HTTPS; leave it off for
+// self-signed certificates, or localhost gets stuck on HTTPS.
+func SecureHeaders(next http.Handler, hsts bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("Referrer-Policy", "same-origin")
+		if hsts {
+			h.Set("Strict-Transport-Security", HSTS_MAX_AGE)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// TLSConfig serves the certificate in certFile and keyFile or, with dev, a
+// fresh self-signed one for localhost.
+func TLSConfig(certFile, keyFile string, dev bool) (*tls.Config, error) {
+	var cert tls.Certificate
+	var err error
+	if dev {
+		cert, err = selfSignedCert(time.Now())
+	} else {
+		cert, err = tls.LoadX509KeyPair(certFile, keyFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+func selfSignedCert(now time.Time) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"Arena dev"}},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(DEV_CERT_VALIDITY),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
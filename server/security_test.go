@@ -0,0 +1,190 @@
+package server
+
+import (
+	"crypto/x509"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"multiplayer-game/auth"
+	"multiplayer-game/engine"
+)
+
+func TestOriginChecker(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		origin  string
+		want    bool
+	}{
+		{name: "no origin", origin: "", want: true},
+		{name: "same origin", origin: "http://arena.example:3000", want: true},
+		{name: "other origin", origin: "https://evil.example", want: false},
+		{name: "listed origin", allowed: []string{"https://Play.example/"}, origin: "https://play.example", want: true},
+		{name: "listed origin other scheme", allowed: []string{"https://play.example"}, origin: "http://play.example", want: false},
+		{name: "any origin", allowed: []string{"*"}, origin: "https://evil.example", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://arena.example:3000/ws", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if got := OriginChecker(tt.allowed)(r); got != tt.want {
+				t.Fatalf("origin %q allowed = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSecureHeaders(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, hsts := range []bool{false, true} {
+		rec := httptest.NewRecorder()
+		SecureHeaders(ok, hsts).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Header().Get("X-Frame-Options") != "DENY" || rec.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Fatalf("headers %v", rec.Header())
+		}
+		if got := rec.Header().Get("Strict-Transport-Security") != ""; got != hsts {
+			t.Fatalf("HSTS sent = %v with hsts %v", got, hsts)
+		}
+	}
+}
+
+func TestDevTLSConfig(t *testing.T) {
+	config, err := TLSConfig("", "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.VerifyHostname("localhost"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.VerifyHostname("127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if !cert.NotAfter.After(time.Now()) {
+		t.Fatalf("dev certificate already expired at %s", cert.NotAfter)
+	}
+
+	if _, err := TLSConfig("missing-cert.pem", "missing-key.pem", false); err == nil {
+		t.Fatal("missing certificate files accepted")
+	}
+}
+
+func TestJoinAuthentication(t *testing.T) {
+	s := NewServer(engine.NewGame(engine.DefaultConfig(), engine.NewManualClock(time.Time{}), rand.New(rand.NewSource(1))))
+	if _, err := s.authenticate(DEFAULT_ROOM, JoinData{Name: "ana"}); err != nil {
+		t.Fatalf("joins are open by default, got %v", err)
+	}
+
+	s.SetAuthenticator(auth.Mock{})
+	if _, err := s.authenticate(DEFAULT_ROOM, JoinData{Name: "ana", Token: "forged"}); errorCode(err) != ERR_AUTH_FAILED {
+		t.Fatalf("bad token: got %q, want %q", errorCode(err), ERR_AUTH_FAILED)
+	}
+	joinData, err := s.authenticate(DEFAULT_ROOM, JoinData{Name: "impostor", Token: "mock:ana"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if joinData.Name != "ana" || joinData.Token != "" {
+		t.Fatalf("join data %+v, want the authenticated name and no credentials", joinData)
+	}
+}
+
+func TestRepeatedAuthFailuresLockOutIP(t *testing.T) {
+	s := NewServer(engine.NewGame(engine.DefaultConfig(), engine.NewManualClock(time.Time{}), rand.New(rand.NewSource(1))))
+	s.SetAuthenticator(auth.SharedPassword{Password: "secret"})
+	server := httptest.NewServer(http.HandlerFunc(s.HandleWebSocket))
+	defer server.Close()
+
+	// tryJoins sends the joins on a new connection and returns the codes of
+	// the rejections it got, and whether it was then closed for a policy
+	// violation.
+	tryJoins := func(password string, joins int) ([]string, bool) {
+		t.Helper()
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+		conn.WriteJSON(map[string]interface{}{"type": "hello", "data": HelloData{Version: PROTOCOL_VERSION}})
+		for i := 0; i < joins; i++ {
+			conn.WriteJSON(map[string]interface{}{"type": "join", "data": JoinData{Name: "ana", Character: "A", Password: password}})
+		}
+
+		var codes []string
+		for {
+			var msg struct {
+				Type string
+				Data engine.GameError
+			}
+			err := conn.ReadJSON(&msg)
+			if websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+				return codes, true
+			}
+			if err != nil {
+				t.Fatalf("after rejections %v: %v", codes, err)
+			}
+			switch msg.Type {
+			case "joinRejected":
+				// A second hello gets an error back only if the server
+				// is still reading this connection.
+				if codes = append(codes, msg.Data.Code); len(codes) == joins {
+					conn.WriteJSON(map[string]interface{}{"type": "hello", "data": HelloData{Version: PROTOCOL_VERSION}})
+				}
+			case "error":
+				return codes, false
+			}
+		}
+	}
+
+	// Failures add up across connections from the same IP.
+	if codes, closed := tryJoins("guess", MAX_AUTH_FAILURES-1); closed {
+		t.Fatalf("first connection closed after %v", codes)
+	}
+	if codes, closed := tryJoins("guess", MAX_AUTH_FAILURES); !closed || len(codes) != 1 || codes[0] != ERR_AUTH_FAILED {
+		t.Fatalf("second connection got %v (closed %v), want one %s and a close", codes, closed, ERR_AUTH_FAILED)
+	}
+	// Locked out, even the right password is turned away unchecked.
+	if codes, closed := tryJoins("secret", 1); !closed || len(codes) != 1 || codes[0] != ERR_RATE_LIMITED {
+		t.Fatalf("locked out connection got %v (closed %v), want %s and a close", codes, closed, ERR_RATE_LIMITED)
+	}
+}
+
+func TestLoginGuard(t *testing.T) {
+	lg := newLoginGuard()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 1; i < MAX_AUTH_FAILURES; i++ {
+		if lg.fail("10.0.0.1", now) {
+			t.Fatalf("locked out after %d failures", i)
+		}
+	}
+	if !lg.fail("10.0.0.1", now) || lg.lockedOut("10.0.0.1", now) != AUTH_LOCKOUT {
+		t.Fatalf("not locked out for %s after %d failures", AUTH_LOCKOUT, MAX_AUTH_FAILURES)
+	}
+	if lg.lockedOut("10.0.0.2", now) != 0 {
+		t.Fatal("another IP is locked out")
+	}
+	if wait := lg.lockedOut("10.0.0.1", now.Add(AUTH_LOCKOUT)); wait != 0 {
+		t.Fatalf("still locked out for %s after the lockout", wait)
+	}
+
+	// Failures spread out further than the lockout never add up.
+	for i := 0; i < 2*MAX_AUTH_FAILURES; i++ {
+		now = now.Add(AUTH_LOCKOUT + time.Second)
+		if lg.fail("10.0.0.3", now) {
+			t.Fatalf("locked out by failures %s apart", AUTH_LOCKOUT+time.Second)
+		}
+	}
+}
@@ -0,0 +1,698 @@
+// Package server connects WebSocket clients to an engine.Game: it speaks
+// the wire protocol, runs the tick loop and sends every client its own view
+// of the world.
+package server
+
+import (
+	"compress/flate"
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"multiplayer-game/auth"
+	"multiplayer-game/engine"
+)
+
+const (
+	PING_INTERVAL = 2 * time.Second
+	PING_TIMEOUT  = time.Second
+	PONG_WAIT     = 10 * time.Second
+
+	MESSAGE_RATE  = 30
+	MESSAGE_BURST = 60
+	FLOOD_LIMIT   = 200
+	// MAX_AUTH_FAILURES rejected logins from one IP within AUTH_LOCKOUT lock
+	// it out for AUTH_LOCKOUT, so reconnecting doesn't buy more guesses.
+	MAX_AUTH_FAILURES = 3
+	AUTH_LOCKOUT      = 30 * time.Second
+
+	COMPRESSION_LEVEL    = flate.BestSpeed
+	COMPRESSION_MIN_SIZE = 256
+
+	DRAIN_POLL_INTERVAL = 50 * time.Millisecond
+
+	CHAT_INTERVAL = 500 * time.Millisecond
+)
+
+// clientConn is the part of a WebSocket connection the server writes to;
+// it is satisfied by *websocket.Conn.
+type clientConn interface {
+	WriteMessage(messageType int, data []byte) error
+	WriteJSON(v interface{}) error
+	WriteControl(messageType int, data []byte, deadline time.Time) error
+	EnableWriteCompression(enable bool)
+	Close() error
+}
+
+type clientInfo struct {
+	player     *engine.Player
+	session    *session
+	lastCells  []byte
+	viewWidth  int
+	viewHeight int
+	camera     engine.Viewport
+	mu         sync.Mutex
+}
+
+type Server struct {
+	game    *engine.Game
+	clients map[clientConn]*clientInfo
+	mutex   sync.RWMutex
+
+	upgrader      websocket.Upgrader
+	authenticator auth.Authenticator
+	logins        *loginGuard
+	relay         func(ChatMessage) error
+	globalBoard   []GlobalLeaderboardEntry
+
+	stopped   bool
+	tickMutex sync.Mutex
+}
+
+func NewServer(game *engine.Game) *Server {
+	return &Server{
+		game:    game,
+		clients: make(map[clientConn]*clientInfo),
+		upgrader: websocket.Upgrader{
+			EnableCompression: true,
+			CheckOrigin:       OriginChecker(nil),
+		},
+		authenticator: auth.None{},
+		logins:        newLoginGuard(),
+	}
+}
+
+// SetAuthenticator makes joins present credentials a accepts. It can be
+// swapped while serving; players already in stay.
+func (s *Server) SetAuthenticator(a auth.Authenticator) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.authenticator = a
+}
+
+// authenticate checks the join's credentials, then drops them and takes the
+// name the authenticator vouches for, if any.
+func (s *Server) authenticate(room string, joinData JoinData) (JoinData, *engine.GameError) {
+	s.mutex.RLock()
+	a := s.authenticator
+	s.mutex.RUnlock()
+
+	identity, err := a.Authenticate(auth.Credentials{Room: room, Name: joinData.Name, Password: joinData.Password, Token: joinData.Token})
+	if err != nil {
+		log.Printf("Authentication failed for %q: %v", joinData.Name, err)
+		return joinData, &engine.GameError{Code: ERR_AUTH_FAILED, Reason: "authentication failed"}
+	}
+	if identity.Name != "" {
+		joinData.Name = identity.Name
+	}
+	joinData.Password, joinData.Token = "", ""
+	return joinData, nil
+}
+
+// Modes reports which optional game modes a room with config has enabled.
+func Modes(config engine.Config) map[string]bool {
+	return map[string]bool{
+		"spectator": true,
+		"fogOfWar":  config.FogOfWar,
+	}
+}
+
+func (ci *clientInfo) updateCamera(state engine.WorldState) engine.Viewport {
+	var playerID string
+	if ci.player != nil {
+		playerID = ci.player.ID
+	}
+	x, y := state.CameraTarget(playerID)
+	ci.camera = engine.FollowCamera(ci.camera, ci.viewWidth, ci.viewHeight, x, y, state.Width, state.Height)
+	return ci.camera
+}
+
+// addClient joins the connection's player, resuming a saved one when the
+// reclaim token matches.
+func (s *Server) addClient(conn clientConn, sess *session, joinData JoinData) (*engine.Player, *engine.GameError) {
+	var player *engine.Player
+	if joinData.ReclaimToken != "" {
+		player, _ = s.game.Reclaim(joinData.ReclaimToken)
+	}
+	reclaimed := player != nil
+	if reclaimed {
+		log.Printf("Player %s reclaimed %s after a restart", player.Name, player.ID)
+	} else {
+		var err *engine.GameError
+		if player, err = s.game.Join(joinData.Name, joinData.Character, joinData.Spectator); err != nil {
+			return nil, err
+		}
+	}
+
+	ci := &clientInfo{player: player, session: sess}
+	if joinData.Viewport != nil {
+		ci.viewWidth, ci.viewHeight = engine.ClampViewportSize(joinData.Viewport.Width, joinData.Viewport.Height)
+	} else {
+		ci.viewWidth, ci.viewHeight = engine.ClampViewportSize(0, 0)
+	}
+
+	welcome := s.welcome(ci, reclaimed)
+
+	s.mutex.Lock()
+	s.clients[conn] = ci
+	welcome.GlobalLeaderboard = s.globalBoard
+	s.mutex.Unlock()
+
+	s.sendToClient(conn, Message{Type: "welcome", Data: welcome})
+
+	s.broadcastState(engine.UPDATE_WORLD | engine.UPDATE_PLAYER_LIST | engine.UPDATE_LEADERBOARD)
+
+	return player, nil
+}
+
+// welcome renders the client's first view of the game. It moves the
+// client's camera, so once the client is shared it needs ci.mu held.
+func (s *Server) welcome(ci *clientInfo, reclaimed bool) WelcomeData {
+	player := ci.player
+	state := s.game.Capture()
+	view := ci.updateCamera(state)
+	world := state.World
+	entities, bullets, items := s.sightOf(player.ID, state)
+	drawn := engine.Blink(entities, state.Tick)
+	welcome := WelcomeData{
+		PlayerID:    player.ID,
+		World:       engine.RenderText(world.RenderViewport(view, drawn, bullets, items), view.Width, view.Height),
+		Map:         world.Map,
+		Items:       engine.ItemKinds,
+		Players:     s.game.PlayerListFor(player.ID),
+		Leaderboard: s.game.Leaderboard(),
+
+		ReclaimToken: player.ReclaimToken(),
+		Reclaimed:    reclaimed,
+	}
+	if ammo, armed := s.game.Ammo(player.ID); armed {
+		welcome.Ammo = &ammo
+	}
+	return welcome
+}
+
+// play puts the client's spectating player into the game with the join's
+// character and welcomes it again, since what it sees changes completely.
+func (s *Server) play(conn clientConn, joinData JoinData) *engine.GameError {
+	s.mutex.RLock()
+	ci, exists := s.clients[conn]
+	board := s.globalBoard
+	s.mutex.RUnlock()
+	if !exists {
+		return &engine.GameError{Code: engine.ERR_NOT_JOINED, Reason: "join before playing"}
+	}
+
+	if err := s.game.Play(ci.player.ID, joinData.Character); err != nil {
+		return err
+	}
+
+	ci.mu.Lock()
+	if joinData.Viewport != nil {
+		ci.viewWidth, ci.viewHeight = engine.ClampViewportSize(joinData.Viewport.Width, joinData.Viewport.Height)
+	}
+	ci.lastCells = nil
+	welcome := s.welcome(ci, false)
+	ci.mu.Unlock()
+	welcome.GlobalLeaderboard = board
+	s.sendToClient(conn, Message{Type: "welcome", Data: welcome})
+
+	s.broadcastState(engine.UPDATE_WORLD | engine.UPDATE_PLAYER_LIST | engine.UPDATE_LEADERBOARD)
+	return nil
+}
+
+// removeClient takes the client's player out of the game before releasing
+// the lock, so that once any caller sees the client gone, its name and
+// character are free again.
+func (s *Server) removeClient(conn clientConn) {
+	s.mutex.Lock()
+	ci, exists := s.clients[conn]
+	delete(s.clients, conn)
+	if exists && ci.player != nil {
+		s.game.Leave(ci.player.ID)
+	}
+	s.mutex.Unlock()
+
+	if !exists {
+		return
+	}
+	s.broadcastState(engine.UPDATE_WORLD | engine.UPDATE_PLAYER_LIST | engine.UPDATE_LEADERBOARD)
+}
+
+func (s *Server) tick() {
+	result := s.game.Tick()
+
+	for conn, ci := range s.clientSnapshot() {
+		if ci.player == nil {
+			continue
+		}
+		for _, move := range result.Moves {
+			if ci.player.ID == move.PlayerID {
+				request, _ := move.Ref.(InboundMessage)
+				s.respond(conn, request, move.Err)
+			}
+		}
+		for _, player := range result.Idle {
+			if ci.player.ID == player.ID {
+				s.handleIdle(conn, player)
+			}
+		}
+		if ammo, changed := result.Ammo[ci.player.ID]; changed {
+			s.sendToClient(conn, Message{Type: "ammo", Data: ammo})
+		}
+	}
+
+	if len(result.Changed) > 0 {
+		s.announceConfig(result.Changed, result.NewWorld)
+	}
+	if result.Updates != 0 {
+		s.broadcastState(result.Updates)
+	}
+}
+
+// announceConfig tells every client about a config change. After a new
+// world the next binary frame must be a keyframe, since the old cells no
+// longer match the map.
+func (s *Server) announceConfig(changed []string, newWorld bool) {
+	config := s.game.Config()
+	data := ConfigChangedData{
+		Changed:         changed,
+		WorldWidth:      config.WorldWidth,
+		WorldHeight:     config.WorldHeight,
+		ShootCooldownMs: int(config.ShootCooldown.Milliseconds()),
+		RespawnTimeMs:   int(config.RespawnTime.Milliseconds()),
+		FogOfWar:        config.FogOfWar,
+	}
+	if newWorld {
+		gameMap := s.game.World().Map
+		data.Map = &gameMap
+		for _, ci := range s.clientSnapshot() {
+			ci.mu.Lock()
+			ci.lastCells = nil
+			ci.mu.Unlock()
+		}
+	}
+	log.Printf("Configuration changed: %s", strings.Join(changed, ", "))
+	s.broadcast(Message{Type: "configChanged", Data: data})
+}
+
+func (s *Server) handleIdle(conn clientConn, player *engine.Player) {
+	config := s.game.Config()
+	log.Printf("Player %s was idle for %s (%s)", player.Name, config.IdleTimeout, config.IdleAction)
+	s.sendToClient(conn, Message{Type: "idle", Data: IdleData{Action: config.IdleAction, TimeoutSeconds: int(config.IdleTimeout.Seconds())}})
+
+	if config.IdleAction == engine.IDLE_ACTION_KICK {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "idle"), time.Now().Add(PING_TIMEOUT))
+		conn.Close()
+	}
+}
+
+// Run ticks the game every tick interval and broadcasts what changed, until
+// Stop. The interval follows reconfigurations.
+func (s *Server) Run() {
+	interval := s.game.Config().TickInterval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.tickMutex.Lock()
+		if s.stopped {
+			s.tickMutex.Unlock()
+			return
+		}
+		s.tick()
+		s.tickMutex.Unlock()
+
+		if next := s.game.Config().TickInterval; next != interval {
+			interval = next
+			ticker.Reset(interval)
+		}
+	}
+}
+
+func (s *Server) shoot(playerID, direction string) *engine.GameError {
+	victim, err := s.game.Shoot(playerID, direction)
+	if victim != nil {
+		s.broadcastState(engine.UPDATE_WORLD | engine.UPDATE_PLAYER_LIST | engine.UPDATE_LEADERBOARD)
+	}
+	return err
+}
+
+func (s *Server) dropClient(conn clientConn, err error) {
+	log.Printf("Error sending message to client: %v", err)
+	conn.Close()
+	s.removeClient(conn)
+}
+
+func (s *Server) writeFrame(conn clientConn, frame outboundFrame) error {
+	conn.EnableWriteCompression(len(frame.payload) >= COMPRESSION_MIN_SIZE)
+	return conn.WriteMessage(frame.messageType, frame.payload)
+}
+
+func (s *Server) writeToClient(conn clientConn, ci *clientInfo, messageType int, payload []byte) {
+	ci.mu.Lock()
+	err := s.writeFrame(conn, outboundFrame{messageType, payload})
+	ci.mu.Unlock()
+
+	if err != nil {
+		s.dropClient(conn, err)
+	}
+}
+
+func (s *Server) sendToClient(conn clientConn, msg Message) {
+	s.mutex.RLock()
+	ci, exists := s.clients[conn]
+	s.mutex.RUnlock()
+	if !exists {
+		return
+	}
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Error encoding %s message: %v", msg.Type, err)
+		return
+	}
+
+	s.writeToClient(conn, ci, websocket.TextMessage, payload)
+}
+
+func (s *Server) clientSnapshot() map[clientConn]*clientInfo {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	clients := make(map[clientConn]*clientInfo, len(s.clients))
+	for conn, ci := range s.clients {
+		clients[conn] = ci
+	}
+	return clients
+}
+
+func (s *Server) broadcast(msg Message) {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Error encoding %s message: %v", msg.Type, err)
+		return
+	}
+
+	for conn, ci := range s.clientSnapshot() {
+		s.writeToClient(conn, ci, websocket.TextMessage, payload)
+	}
+}
+
+type outboundFrame struct {
+	messageType int
+	payload     []byte
+}
+
+func (s *Server) setViewport(conn clientConn, width, height int) *engine.GameError {
+	s.mutex.RLock()
+	ci, exists := s.clients[conn]
+	s.mutex.RUnlock()
+	if !exists {
+		return &engine.GameError{Code: engine.ERR_NOT_JOINED, Reason: "join before setting the viewport"}
+	}
+
+	ci.mu.Lock()
+	ci.viewWidth, ci.viewHeight = engine.ClampViewportSize(width, height)
+	ci.mu.Unlock()
+	return nil
+}
+
+// broadcastState sends every client what changed. Under fog of war each
+// client gets its own player list, without the positions it cannot see.
+func (s *Server) broadcastState(updates int) {
+	state := s.game.Capture()
+	fogged := s.game.Config().FogOfWar
+
+	var lists []Message
+	if updates&engine.UPDATE_PLAYER_LIST != 0 && !fogged {
+		lists = append(lists, Message{Type: "playerList", Data: s.game.PlayerList()})
+	}
+	if updates&engine.UPDATE_LEADERBOARD != 0 {
+		lists = append(lists, Message{Type: "leaderboard", Data: s.game.Leaderboard()})
+	}
+
+	worldUpdate := updates&engine.UPDATE_WORLD != 0
+	encodedLists := make([]json.RawMessage, 0, len(lists))
+	for _, msg := range lists {
+		payload, err := json.Marshal(msg)
+		if err != nil {
+			log.Printf("Error encoding %s message: %v", msg.Type, err)
+			return
+		}
+		encodedLists = append(encodedLists, payload)
+	}
+
+	for conn, ci := range s.clientSnapshot() {
+		binaryWorld := worldUpdate && ci.session != nil && ci.session.capabilities[CAPABILITY_BINARY]
+		batched := ci.session != nil && ci.session.capabilities[CAPABILITY_BATCH]
+		predicting := worldUpdate && ci.session != nil && ci.session.capabilities[CAPABILITY_PREDICTION]
+
+		clientLists := encodedLists
+		if updates&engine.UPDATE_PLAYER_LIST != 0 && fogged {
+			var playerID string
+			if ci.player != nil {
+				playerID = ci.player.ID
+			}
+			payload, err := json.Marshal(Message{Type: "playerList", Data: s.game.PlayerListFor(playerID)})
+			if err != nil {
+				log.Printf("Error encoding playerList message: %v", err)
+				return
+			}
+			clientLists = append([]json.RawMessage{payload}, encodedLists...)
+		}
+
+		var writeErr error
+		ci.mu.Lock()
+		frames, err := s.clientFrames(ci, state, clientLists, worldUpdate, binaryWorld, batched, predicting)
+		if err != nil {
+			ci.mu.Unlock()
+			log.Printf("Error encoding state update: %v", err)
+			return
+		}
+
+		for _, frame := range frames {
+			if writeErr = s.writeFrame(conn, frame); writeErr != nil {
+				break
+			}
+		}
+		ci.mu.Unlock()
+
+		if writeErr != nil {
+			s.dropClient(conn, writeErr)
+		}
+	}
+}
+
+func (s *Server) visibleTo(playerID string, state engine.WorldState) ([]engine.EntityState, []engine.Bullet, []engine.Item) {
+	var viewer *engine.EntityState
+	for i := range state.Entities {
+		if state.Entities[i].ID == playerID {
+			viewer = &state.Entities[i]
+			break
+		}
+	}
+	if viewer == nil {
+		return state.Entities, state.Bullets, state.Items
+	}
+
+	radius := s.game.Config().VisionRadius
+	entities := make([]engine.EntityState, 0, len(state.Entities))
+	for _, entity := range state.Entities {
+		if entity.ID == playerID || state.World.CanSee(viewer.X, viewer.Y, entity.X, entity.Y, radius) {
+			entities = append(entities, entity)
+		}
+	}
+
+	bullets := make([]engine.Bullet, 0, len(state.Bullets))
+	for _, bullet := range state.Bullets {
+		if state.World.CanSee(viewer.X, viewer.Y, bullet.X, bullet.Y, radius) {
+			bullets = append(bullets, bullet)
+		}
+	}
+
+	items := make([]engine.Item, 0, len(state.Items))
+	for _, item := range state.Items {
+		if state.World.CanSee(viewer.X, viewer.Y, item.X, item.Y, radius) {
+			items = append(items, item)
+		}
+	}
+
+	return entities, bullets, items
+}
+
+// sightOf is what the player may be shown of state: under fog of war only
+// what it can see, and never anyone else's invisible players.
+func (s *Server) sightOf(playerID string, state engine.WorldState) ([]engine.EntityState, []engine.Bullet, []engine.Item) {
+	entities, bullets, items := state.Entities, state.Bullets, state.Items
+	if playerID != "" && s.game.Config().FogOfWar {
+		entities, bullets, items = s.visibleTo(playerID, state)
+	}
+	return engine.Conceal(entities, playerID), bullets, items
+}
+
+func (s *Server) clientFrames(ci *clientInfo, state engine.WorldState, lists []json.RawMessage, worldUpdate, binaryWorld, batched, predicting bool) ([]outboundFrame, error) {
+	var view engine.Viewport
+	var cells []byte
+	var playerID string
+	if ci.player != nil {
+		playerID = ci.player.ID
+	}
+	entities, bullets, items := s.sightOf(playerID, state)
+	drawn := engine.Blink(entities, state.Tick)
+	if worldUpdate {
+		view = ci.updateCamera(state)
+		cells = state.World.RenderViewport(view, drawn, bullets, items)
+	}
+
+	parts := make([]json.RawMessage, 0, len(lists)+2)
+	if worldUpdate && !binaryWorld {
+		payload, err := json.Marshal(Message{Type: "worldUpdate", Data: engine.RenderText(cells, view.Width, view.Height)})
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, payload)
+	}
+	parts = append(parts, lists...)
+
+	if predicting && ci.player != nil {
+		visible := make([]engine.EntityState, 0, len(entities))
+		for _, entity := range entities {
+			if entity.ID == ci.player.ID || view.Contains(entity.X, entity.Y) {
+				visible = append(visible, entity)
+			}
+		}
+
+		payload, err := json.Marshal(Message{Type: "snapshot", Data: SnapshotData{
+			Tick:     state.Tick,
+			LastSeq:  state.LastSeqs[ci.player.ID],
+			Width:    state.Width,
+			Height:   state.Height,
+			Viewport: view,
+			Players:  visible,
+		}})
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, payload)
+	}
+
+	frames, err := encodeFrames(parts, batched)
+	if err != nil {
+		return nil, err
+	}
+
+	if binaryWorld {
+		keyframe := encodeEntityFrame(view, drawn, bullets, items)
+		frame := worldFrame(view, keyframe, ci.lastCells, cells)
+		ci.lastCells = cells
+		frames = append([]outboundFrame{{websocket.BinaryMessage, frame}}, frames...)
+	}
+
+	return frames, nil
+}
+
+func encodeFrames(messages []json.RawMessage, batched bool) ([]outboundFrame, error) {
+	if len(messages) == 0 {
+		return nil, nil
+	}
+
+	if batched && len(messages) > 1 {
+		payload, err := json.Marshal(Message{Type: "batch", Data: messages})
+		if err != nil {
+			return nil, err
+		}
+		return []outboundFrame{{websocket.TextMessage, payload}}, nil
+	}
+
+	frames := make([]outboundFrame, 0, len(messages))
+	for _, payload := range messages {
+		frames = append(frames, outboundFrame{websocket.TextMessage, payload})
+	}
+	return frames, nil
+}
+
+func (s *Server) send(conn clientConn, msg Message) {
+	s.mutex.RLock()
+	_, joined := s.clients[conn]
+	s.mutex.RUnlock()
+
+	if joined {
+		s.sendToClient(conn, msg)
+		return
+	}
+
+	if err := conn.WriteJSON(msg); err != nil {
+		log.Printf("Error sending message to client: %v", err)
+	}
+}
+
+func (s *Server) respond(conn clientConn, request InboundMessage, err *engine.GameError) {
+	if err == nil {
+		if request.ID != "" {
+			s.send(conn, Message{Type: "ack", ID: request.ID, Data: AckData{RequestType: request.Type}})
+		}
+		return
+	}
+
+	reply := *err
+	reply.RequestType = request.Type
+
+	msgType := "error"
+	if request.ID != "" {
+		msgType = "nack"
+	}
+
+	s.send(conn, Message{Type: msgType, ID: request.ID, Data: reply})
+}
+
+// Stop ends Run. Once it returns no tick is running or will start, so the
+// game can be snapshotted.
+func (s *Server) Stop() {
+	s.tickMutex.Lock()
+	s.stopped = true
+	s.tickMutex.Unlock()
+}
+
+func (s *Server) clientCount() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.clients)
+}
+
+// Shutdown stops the game, tells every client the server is going away and
+// closes their connections, then waits for them to drain. Connections still
+// open when ctx is done are closed forcibly.
+func (s *Server) Shutdown(ctx context.Context, resumable bool) {
+	s.Stop()
+
+	data := ServerShutdownData{Reason: "server is shutting down", Resumable: resumable}
+	if resumable {
+		data.ReclaimWindowSeconds = int(engine.RECLAIM_WINDOW.Seconds())
+	}
+	s.broadcast(Message{Type: "serverShutdown", Data: data})
+
+	closing := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutdown")
+	for conn := range s.clientSnapshot() {
+		conn.WriteControl(websocket.CloseMessage, closing, time.Now().Add(PING_TIMEOUT))
+	}
+
+	poll := time.NewTicker(DRAIN_POLL_INTERVAL)
+	defer poll.Stop()
+	for s.clientCount() > 0 {
+		select {
+		case <-ctx.Done():
+			log.Printf("Closing %d connections that did not drain in time", s.clientCount())
+			for conn := range s.clientSnapshot() {
+				conn.Close()
+				s.removeClient(conn)
+			}
+			return
+		case <-poll.C:
+		}
+	}
+}
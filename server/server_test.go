@@ -0,0 +1,400 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"multiplayer-game/engine"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// fakeConn is an in-memory client connection that records what the server
+// writes. With failAfter set, writes start failing after that many
+// messages, like a client that went away.
+type fakeConn struct {
+	mu        sync.Mutex
+	messages  [][]byte
+	closed    bool
+	failAfter int
+}
+
+func (c *fakeConn) WriteMessage(messageType int, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed || (c.failAfter > 0 && len(c.messages) >= c.failAfter) {
+		return errors.New("connection closed")
+	}
+	c.messages = append(c.messages, append([]byte(nil), data...))
+	return nil
+}
+
+func (c *fakeConn) WriteJSON(v interface{}) error {
+	return c.WriteMessage(1, nil)
+}
+
+func (c *fakeConn) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	return nil
+}
+
+func (c *fakeConn) EnableWriteCompression(enable bool) {}
+
+func (c *fakeConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+func errorCode(err *engine.GameError) string {
+	if err == nil {
+		return ""
+	}
+	return err.Code
+}
+
+// This test is meant to be run with -race: it hammers the server from many
+// goroutines and checks that its bookkeeping stays consistent.
+func TestConcurrentJoinLeaveAndBroadcast(t *testing.T) {
+	game := engine.NewGame(engine.DefaultConfig(), engine.SystemClock{}, rand.New(rand.NewSource(1)))
+	s := NewServer(game)
+
+	const clients = 32
+	const rounds = 20
+
+	stop := make(chan struct{})
+	var background sync.WaitGroup
+	background.Add(2)
+	go func() {
+		defer background.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				s.tick()
+			}
+		}
+	}()
+	go func() {
+		defer background.Done()
+		// Alternate between two configs, one of which needs a new world.
+		configs := []engine.Config{engine.DefaultConfig(), engine.DefaultConfig()}
+		configs[1].FogOfWar = true
+		configs[1].MapSeed = 2
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				if _, err := game.Reconfigure(configs[i%2]); err != nil {
+					t.Error(err)
+				}
+				s.broadcast(Message{Type: "playerList", Data: game.PlayerList()})
+				s.broadcastState(engine.UPDATE_WORLD | engine.UPDATE_LEADERBOARD)
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for round := 0; round < rounds; round++ {
+				conn := &fakeConn{}
+				if i%4 == 0 {
+					// Some clients go away mid-broadcast and get dropped.
+					conn.failAfter = 3
+				}
+
+				sess := &session{version: PROTOCOL_VERSION, capabilities: map[string]bool{
+					CAPABILITY_BINARY:     i%2 == 0,
+					CAPABILITY_BATCH:      i%3 == 0,
+					CAPABILITY_PREDICTION: true,
+				}}
+				player, err := s.addClient(conn, sess, JoinData{
+					Name:      fmt.Sprintf("c%d-%d", i, round),
+					Character: string(rune('0' + i)),
+					Spectator: i%5 == 0,
+				})
+				if err != nil {
+					t.Errorf("join: %v", err)
+					return
+				}
+
+				game.QueueMove(player.ID, "left", 0, InboundMessage{})
+				s.shoot(player.ID, "right")
+				s.setViewport(conn, 40+i, 20)
+				s.sendToClient(conn, Message{Type: "ack"})
+				s.removeClient(conn)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(stop)
+	background.Wait()
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if players := game.PlayerList(); len(s.clients) != 0 || len(players) != 0 {
+		t.Fatalf("%d clients and %d players left after everyone disconnected", len(s.clients), len(players))
+	}
+	if state := game.Capture(); len(state.Entities) != 0 {
+		t.Fatalf("%d entities left in the world after everyone disconnected", len(state.Entities))
+	}
+}
+
+func TestWorldFramesShowPowerUpsAndHideInvisiblePlayers(t *testing.T) {
+	config := engine.DefaultConfig()
+	config.MapCellsPerWall = 0
+	s := NewServer(engine.NewGame(config, engine.NewManualClock(time.Now()), rand.New(rand.NewSource(1))))
+	state := engine.WorldState{
+		World:  engine.NewGameWorld(config),
+		Width:  config.WorldWidth,
+		Height: config.WorldHeight,
+		Entities: []engine.EntityState{
+			{ID: "p1", Character: "A", X: 5, Y: 5, Invisible: true},
+			{ID: "p2", Character: "B", X: 7, Y: 5},
+		},
+		Items: []engine.Item{{ID: "powerup_1", Kind: engine.POWER_UP_SPEED, X: 9, Y: 5}},
+	}
+
+	for _, binaryWorld := range []bool{false, true} {
+		ci := &clientInfo{
+			player:     &engine.Player{ID: "p2"},
+			session:    &session{version: PROTOCOL_VERSION},
+			viewWidth:  engine.MIN_VIEWPORT_WIDTH,
+			viewHeight: engine.MIN_VIEWPORT_HEIGHT,
+		}
+		frames, err := s.clientFrames(ci, state, nil, true, binaryWorld, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frame := string(frames[0].payload)
+		if strings.Contains(frame, "A") || !strings.Contains(frame, "B") || !strings.Contains(frame, "~") {
+			t.Fatalf("binary %v: frame %q should show B and the speed power-up but not the invisible A", binaryWorld, frame)
+		}
+	}
+}
+
+func TestVisibleTo(t *testing.T) {
+	config := engine.DefaultConfig()
+	config.FogOfWar = true
+	s := NewServer(engine.NewGame(config, engine.NewManualClock(time.Now()), rand.New(rand.NewSource(1))))
+	walled := engine.NewGameWorld(config)
+	wall := walled.Map.Walls[0]
+	config.MapCellsPerWall = 0
+	open := engine.NewGameWorld(config)
+
+	tests := []struct {
+		name     string
+		world    *engine.GameWorld
+		viewer   string
+		from, to [2]int
+		visible  bool
+	}{
+		{"beside the viewer", walled, "viewer", [2]int{wall.X - 1, wall.Y}, [2]int{wall.X - 1, wall.Y - 1}, true},
+		{"behind a wall", walled, "viewer", [2]int{wall.X - 1, wall.Y}, [2]int{wall.X + wall.Width, wall.Y}, false},
+		{"at the vision radius", open, "viewer", [2]int{100, 60}, [2]int{100 + engine.VISION_RADIUS, 60}, true},
+		{"past the vision radius", open, "viewer", [2]int{100, 60}, [2]int{100, 60 + engine.VISION_RADIUS + 1}, false},
+		{"spectator", walled, "spectator", [2]int{wall.X - 1, wall.Y}, [2]int{wall.X + wall.Width, wall.Y}, true},
+	}
+	for _, tt := range tests {
+		state := engine.WorldState{
+			World: tt.world,
+			Entities: []engine.EntityState{
+				{ID: "viewer", Character: "V", X: tt.from[0], Y: tt.from[1]},
+				{ID: "other", Character: "O", X: tt.to[0], Y: tt.to[1]},
+			},
+			Bullets: []engine.Bullet{{ID: "bullet_1", X: tt.to[0], Y: tt.to[1]}},
+			Items:   []engine.Item{{ID: "powerup_1", Kind: engine.POWER_UP_SPEED, X: tt.to[0], Y: tt.to[1]}},
+		}
+		entities, bullets, items := s.visibleTo(tt.viewer, state)
+		if (len(entities) == 2) != tt.visible || (len(bullets) == 1) != tt.visible || (len(items) == 1) != tt.visible {
+			t.Errorf("%s: got %d entities, %d bullets and %d items, want visible = %v", tt.name, len(entities), len(bullets), len(items), tt.visible)
+		}
+	}
+}
+
+// A fogged client must not learn where hidden players are from anything it
+// is sent, starting with the welcome.
+func TestFogHidesPlayersFromClients(t *testing.T) {
+	config := engine.DefaultConfig()
+	config.FogOfWar = true
+	config.VisionRadius = 1
+	config.MapCellsPerWall = 0
+	config.WorldWidth, config.WorldHeight = 80, 40
+	s := NewServer(engine.NewGame(config, engine.NewManualClock(time.Now()), rand.New(rand.NewSource(1))))
+
+	capabilities := map[string]bool{CAPABILITY_PREDICTION: true}
+	viewport := &ViewportData{Width: engine.MAX_VIEWPORT_WIDTH, Height: engine.MAX_VIEWPORT_HEIGHT}
+	if _, err := s.addClient(&fakeConn{}, &session{version: PROTOCOL_VERSION, capabilities: capabilities}, JoinData{Name: "hidden", Character: "H", Viewport: viewport}); err != nil {
+		t.Fatal(err)
+	}
+	conn := &fakeConn{}
+	if _, err := s.addClient(conn, &session{version: PROTOCOL_VERSION, capabilities: capabilities}, JoinData{Name: "viewer", Character: "V", Viewport: viewport}); err != nil {
+		t.Fatal(err)
+	}
+
+	var welcome WelcomeData
+	if !findMessage(conn, "welcome", &welcome) {
+		t.Fatal("no welcome")
+	}
+	if strings.Contains(welcome.World, "H") || !strings.Contains(welcome.World, "V") {
+		t.Fatalf("welcome world should show V but not the hidden H:\n%s", welcome.World)
+	}
+	for _, entry := range welcome.Players {
+		if entry.Name == "hidden" && entry.Position != "(?,?)" {
+			t.Fatalf("welcome player list places the hidden player at %s", entry.Position)
+		}
+	}
+
+	for _, raw := range conn.messages[1:] {
+		var msg struct {
+			Type string
+			Data json.RawMessage
+		}
+		json.Unmarshal(raw, &msg)
+		var world string
+		var snapshot SnapshotData
+		switch {
+		case msg.Type == "worldUpdate" && json.Unmarshal(msg.Data, &world) == nil && strings.Contains(world, "H"):
+			t.Fatalf("world update shows the hidden player:\n%s", world)
+		case msg.Type == "snapshot" && json.Unmarshal(msg.Data, &snapshot) == nil && len(snapshot.Players) != 1:
+			t.Fatalf("snapshot lists %+v, want only the viewer", snapshot.Players)
+		}
+	}
+}
+
+func TestAmmoSentToShooter(t *testing.T) {
+	config := engine.DefaultConfig()
+	config.MapCellsPerWall = 0
+	clock := engine.NewManualClock(time.Now())
+	s := NewServer(engine.NewGame(config, clock, rand.New(rand.NewSource(1))))
+
+	conns := []*fakeConn{{}, {}}
+	var players []*engine.Player
+	for i, conn := range conns {
+		player, err := s.addClient(conn, &session{version: PROTOCOL_VERSION, capabilities: map[string]bool{}}, JoinData{Name: fmt.Sprintf("p%d", i), Character: string(rune('A' + i))})
+		if err != nil {
+			t.Fatal(err)
+		}
+		players = append(players, player)
+	}
+	var welcome struct {
+		Type string
+		Data WelcomeData
+	}
+	if err := json.Unmarshal(conns[0].messages[0], &welcome); err != nil || welcome.Data.Ammo == nil || welcome.Data.Ammo.Ammo != engine.MAGAZINE_SIZE {
+		t.Fatalf("welcome %s should carry a full magazine (%v)", conns[0].messages[0], err)
+	}
+
+	// The first tick reports the loadouts handed out on joining.
+	clock.Advance(config.TickInterval)
+	s.tick()
+	if err := s.shoot(players[0].ID, "up"); err != nil {
+		t.Fatal(err)
+	}
+	sent := []int{len(conns[0].messages), len(conns[1].messages)}
+	clock.Advance(config.TickInterval)
+	s.tick()
+
+	ammoMessages := func(conn *fakeConn, from int) []engine.AmmoState {
+		var states []engine.AmmoState
+		for _, raw := range conn.messages[from:] {
+			var msg struct {
+				Type string
+				Data engine.AmmoState
+			}
+			if json.Unmarshal(raw, &msg) == nil && msg.Type == "ammo" {
+				states = append(states, msg.Data)
+			}
+		}
+		return states
+	}
+	if states := ammoMessages(conns[0], sent[0]); len(states) != 1 || states[0].Ammo != engine.MAGAZINE_SIZE-1 {
+		t.Fatalf("shooter got ammo updates %+v, want one with %d rounds", states, engine.MAGAZINE_SIZE-1)
+	}
+	if states := ammoMessages(conns[1], sent[1]); len(states) != 0 {
+		t.Fatalf("bystander got ammo updates %+v", states)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	tests := []struct {
+		name     string
+		tokens   float64
+		idle     time.Duration
+		gap      time.Duration
+		messages int
+		allowed  int
+		flooding bool
+	}{
+		{"burst", MESSAGE_BURST, 0, 0, MESSAGE_BURST + 1, MESSAGE_BURST, false},
+		{"refill is capped at the burst", 0, time.Minute, 0, MESSAGE_BURST + 1, MESSAGE_BURST, false},
+		{"at the steady rate", 0, 0, 34 * time.Millisecond, 10 * MESSAGE_BURST, 10 * MESSAGE_BURST, false},
+		// Every 20ms earns 0.6 tokens, so 99 messages earn 59.4.
+		{"faster than the steady rate", 0, 0, 20 * time.Millisecond, 99, 59, false},
+		{"denied up to the flood limit", MESSAGE_BURST, 0, 0, MESSAGE_BURST + FLOOD_LIMIT, MESSAGE_BURST, false},
+		{"flooding", MESSAGE_BURST, 0, 0, MESSAGE_BURST + FLOOD_LIMIT + 1, MESSAGE_BURST, true},
+	}
+	for _, tt := range tests {
+		now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+		rl := &rateLimiter{tokens: tt.tokens, last: now}
+		now = now.Add(tt.idle)
+
+		allowed := 0
+		for i := 0; i < tt.messages; i++ {
+			now = now.Add(tt.gap)
+			if rl.allow(now) {
+				allowed++
+			}
+		}
+		if allowed != tt.allowed || rl.flooding() != tt.flooding {
+			t.Errorf("%s: %d of %d allowed, flooding = %v; want %d allowed, flooding = %v", tt.name, allowed, tt.messages, rl.flooding(), tt.allowed, tt.flooding)
+		}
+	}
+}
+
+func TestSpectatorJoinsGame(t *testing.T) {
+	s := NewServer(engine.NewGame(engine.DefaultConfig(), engine.NewManualClock(time.Now()), rand.New(rand.NewSource(1))))
+	conn := &fakeConn{}
+	player, err := s.addClient(conn, &session{version: PROTOCOL_VERSION, capabilities: map[string]bool{}}, JoinData{Name: "watcher", Spectator: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.play(conn, JoinData{Character: "W"}); err != nil {
+		t.Fatal(err)
+	}
+	var welcomes []WelcomeData
+	for _, raw := range conn.messages {
+		var msg struct {
+			Type string
+			Data WelcomeData
+		}
+		if json.Unmarshal(raw, &msg) == nil && msg.Type == "welcome" {
+			welcomes = append(welcomes, msg.Data)
+		}
+	}
+	if len(welcomes) != 2 || welcomes[1].PlayerID != player.ID || welcomes[1].Ammo == nil || !strings.Contains(welcomes[1].World, "W") {
+		t.Fatalf("welcomes %+v, want a second one showing the player armed", welcomes)
+	}
+	if s.game.Spectating(player.ID) {
+		t.Fatal("still spectating")
+	}
+}
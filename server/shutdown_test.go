@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"multiplayer-game/engine"
+)
+
+// findMessage decodes the data of the first message of type msgType that
+// conn received into v.
+func findMessage(conn *fakeConn, msgType string, v interface{}) bool {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	for _, raw := range conn.messages {
+		var msg struct {
+			Type string          `json:"type"`
+			Data json.RawMessage `json:"data"`
+		}
+		if json.Unmarshal(raw, &msg) == nil && msg.Type == msgType {
+			return json.Unmarshal(msg.Data, v) == nil
+		}
+	}
+	return false
+}
+
+func newTestRooms() *Rooms {
+	clock := engine.NewManualClock(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	game := engine.NewGame(engine.DefaultConfig(), clock, rand.New(rand.NewSource(1)))
+	return NewRooms(map[string]*Server{DEFAULT_ROOM: NewServer(game)})
+}
+
+func TestShutdownResumesMatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	rooms := newTestRooms()
+	s, _ := rooms.Get(DEFAULT_ROOM)
+	conn := &fakeConn{}
+	sess := &session{version: PROTOCOL_VERSION, capabilities: map[string]bool{}}
+	player, err := s.addClient(conn, sess, JoinData{Name: "ana", Character: "A"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	player.Kills = 3
+
+	// Nothing reads from the fake connection, so it only goes away once the
+	// drain times out.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := rooms.Shutdown(ctx, path); err != nil {
+		t.Fatal(err)
+	}
+
+	var shutdown ServerShutdownData
+	if !findMessage(conn, "serverShutdown", &shutdown) || !shutdown.Resumable || shutdown.ReclaimWindowSeconds == 0 {
+		t.Fatalf("serverShutdown = %+v, want a resumable shutdown", shutdown)
+	}
+	if !conn.closed || s.clientCount() != 0 {
+		t.Fatal("client still connected after the drain timed out")
+	}
+
+	restarted := newTestRooms()
+	if err := restarted.RestoreSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("snapshot not removed after restoring it")
+	}
+	if err := restarted.RestoreSnapshot(path); err != nil {
+		t.Fatalf("restoring without a snapshot: %v", err)
+	}
+
+	s, _ = restarted.Get(DEFAULT_ROOM)
+	conn = &fakeConn{}
+	reclaimed, err := s.addClient(conn, sess, JoinData{Name: "ana", Character: "A", ReclaimToken: player.ReclaimToken()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var welcome WelcomeData
+	if !findMessage(conn, "welcome", &welcome) || !welcome.Reclaimed || welcome.PlayerID != player.ID {
+		t.Fatalf("welcome = %+v, want %s reclaimed", welcome, player.ID)
+	}
+	if reclaimed.Kills != 3 {
+		t.Fatalf("kills = %d after the restart, want 3", reclaimed.Kills)
+	}
+
+	conn = &fakeConn{}
+	if _, err := s.addClient(conn, sess, JoinData{Name: "bia", Character: "B", ReclaimToken: "stale"}); err != nil {
+		t.Fatalf("a stale reclaim token should fall back to a normal join: %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	"multiplayer-game/engine"
+)
+
+const SNAPSHOT_VERSION = 1
+
+// snapshotFile is what SaveSnapshot writes: every room's game, by name.
+type snapshotFile struct {
+	Version int                        `json:"version"`
+	Rooms   map[string]engine.Snapshot `json:"rooms"`
+}
+
+// SaveSnapshot writes every room's game to path. The rooms should be
+// stopped first so the snapshot is consistent.
+func (rs *Rooms) SaveSnapshot(path string) error {
+	saved := snapshotFile{Version: SNAPSHOT_VERSION, Rooms: make(map[string]engine.Snapshot, len(rs.servers))}
+	for name, s := range rs.servers {
+		saved.Rooms[name] = s.game.Snapshot()
+	}
+
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write then rename, so a crash mid-write never leaves half a snapshot.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// RestoreSnapshot resumes the games saved at path, if there is one, then
+// removes it so a match is only resumed once. Call it before Run.
+func (rs *Rooms) RestoreSnapshot(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var saved snapshotFile
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if saved.Version != SNAPSHOT_VERSION {
+		return fmt.Errorf("%s: unsupported snapshot version %d", path, saved.Version)
+	}
+
+	for name, snapshot := range saved.Rooms {
+		s, ok := rs.servers[name]
+		if !ok {
+			log.Printf("Room %s no longer exists, dropping its saved match", name)
+			continue
+		}
+		s.game.Restore(snapshot)
+	}
+	return os.Remove(path)
+}
+
+// Shutdown stops every room, saves them to snapshotPath unless it is empty,
+// then tells clients and drains their connections until ctx is done. A
+// failed snapshot is returned, but clients are still let go.
+func (rs *Rooms) Shutdown(ctx context.Context, snapshotPath string) error {
+	for _, s := range rs.servers {
+		s.Stop()
+	}
+
+	var err error
+	if snapshotPath != "" {
+		err = rs.SaveSnapshot(snapshotPath)
+	}
+	resumable := snapshotPath != "" && err == nil
+
+	var wg sync.WaitGroup
+	for _, s := range rs.servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Shutdown(ctx, resumable)
+		}()
+	}
+	wg.Wait()
+	return err
+}
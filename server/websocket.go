@@ -0,0 +1,268 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"multiplayer-game/engine"
+)
+
+type rateLimiter struct {
+	tokens     float64
+	last       time.Time
+	violations int
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{tokens: MESSAGE_BURST, last: time.Now()}
+}
+
+func (rl *rateLimiter) allow(now time.Time) bool {
+	rl.tokens = math.Min(MESSAGE_BURST, rl.tokens+now.Sub(rl.last).Seconds()*MESSAGE_RATE)
+	rl.last = now
+
+	if rl.tokens < 1 {
+		rl.violations++
+		return false
+	}
+
+	rl.tokens--
+	if rl.violations > 0 {
+		rl.violations--
+	}
+	return true
+}
+
+func (rl *rateLimiter) flooding() bool {
+	return rl.violations > FLOOD_LIMIT
+}
+
+func pingLoop(conn *websocket.Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(PING_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			payload := strconv.FormatInt(now.UnixNano(), 10)
+			if err := conn.WriteControl(websocket.PingMessage, []byte(payload), now.Add(PING_TIMEOUT)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// HandleWebSocket upgrades the request and serves one client until it
+// disconnects.
+func (s *Server) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	room := r.URL.Query().Get("room")
+	if room == "" {
+		room = DEFAULT_ROOM
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	if err := conn.SetCompressionLevel(COMPRESSION_LEVEL); err != nil {
+		log.Printf("Error setting compression level: %v", err)
+	}
+
+	var player *engine.Player
+	var sess *session
+	var lastChat time.Time
+	ip := remoteIP(r)
+	limiter := newRateLimiter()
+
+	conn.SetReadDeadline(time.Now().Add(PONG_WAIT))
+	conn.SetPongHandler(func(payload string) error {
+		conn.SetReadDeadline(time.Now().Add(PONG_WAIT))
+		sent, err := strconv.ParseInt(payload, 10, 64)
+		if err == nil && player != nil {
+			s.game.RecordRTT(player.ID, time.Since(time.Unix(0, sent)))
+		}
+		return nil
+	})
+
+	done := make(chan struct{})
+	defer close(done)
+	go pingLoop(conn, done)
+
+	for {
+		_, raw, err := conn.ReadMessage()
+		if err != nil {
+			log.Printf("Error reading message: %v", err)
+			break
+		}
+		conn.SetReadDeadline(time.Now().Add(PONG_WAIT))
+
+		if !limiter.allow(time.Now()) {
+			if limiter.flooding() {
+				log.Printf("Disconnecting %s for flooding", conn.RemoteAddr())
+				s.respond(conn, InboundMessage{}, &engine.GameError{Code: ERR_RATE_LIMITED, Reason: "too many messages, disconnecting"})
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "flooding"), time.Now().Add(time.Second))
+				break
+			}
+
+			s.respond(conn, InboundMessage{}, &engine.GameError{Code: ERR_RATE_LIMITED, Reason: fmt.Sprintf("limit is %d messages per second", MESSAGE_RATE)})
+			continue
+		}
+
+		msg, payload, decodeErr := decodeMessage(raw)
+		if decodeErr != nil {
+			s.respond(conn, msg, decodeErr)
+			continue
+		}
+
+		if sess == nil && msg.Type != "hello" {
+			s.respond(conn, msg, &engine.GameError{Code: ERR_HANDSHAKE_REQUIRED, Reason: "send hello before any other message"})
+			continue
+		}
+
+		switch data := payload.(type) {
+		case *HelloData:
+			if sess != nil {
+				s.respond(conn, msg, &engine.GameError{Code: ERR_ALREADY_JOINED, Reason: "handshake already completed"})
+				continue
+			}
+
+			negotiated, capabilities, helloErr := negotiate(data)
+			if helloErr != nil {
+				s.respond(conn, msg, helloErr)
+				continue
+			}
+
+			sess = negotiated
+			s.send(conn, Message{Type: "hello", ID: msg.ID, Data: HelloReplyData{Version: sess.version, Capabilities: capabilities}})
+
+		case *JoinData:
+			if player != nil && (data.Spectator || !s.game.Spectating(player.ID)) {
+				s.respond(conn, msg, &engine.GameError{Code: ERR_ALREADY_JOINED, Reason: "this connection has already joined"})
+				continue
+			}
+			if player != nil {
+				// Spectators, idle players moved there included, join the
+				// game without logging in again.
+				if playErr := s.play(conn, *data); playErr != nil {
+					s.rejectJoin(conn, msg, playErr)
+					continue
+				}
+				s.respond(conn, msg, nil)
+				log.Printf("Spectator %s joined the game as %s", player.Name, data.Character)
+				continue
+			}
+
+			var joinErr *engine.GameError
+			lockedOut := false
+			if wait := s.logins.lockedOut(ip, time.Now()); wait > 0 {
+				joinErr = &engine.GameError{Code: ERR_RATE_LIMITED, Reason: fmt.Sprintf("too many failed logins, try again in %ds", int(math.Ceil(wait.Seconds())))}
+				lockedOut = true
+			}
+			var joinData JoinData
+			if joinErr == nil {
+				joinData, joinErr = s.authenticate(room, *data)
+				if joinErr != nil && joinErr.Code == ERR_AUTH_FAILED {
+					lockedOut = s.logins.fail(ip, time.Now())
+				}
+			}
+			var joined *engine.Player
+			if joinErr == nil {
+				joined, joinErr = s.addClient(conn, sess, joinData)
+			}
+			if joinErr != nil {
+				log.Printf("Join rejected for %q: %v", data.Name, joinErr)
+				s.rejectJoin(conn, msg, joinErr)
+				if lockedOut {
+					log.Printf("Disconnecting %s after too many failed logins", ip)
+					conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "too many failed logins"), time.Now().Add(time.Second))
+					return
+				}
+				continue
+			}
+
+			player = joined
+			s.respond(conn, msg, nil)
+			log.Printf("Player %s (%s) joined the game as %s", player.Name, player.Character, player.ID)
+
+		case *MoveData:
+			if player == nil {
+				s.respond(conn, msg, &engine.GameError{Code: engine.ERR_NOT_JOINED, Reason: "join before moving"})
+				continue
+			}
+
+			if moveErr := s.game.QueueMove(player.ID, data.Direction, data.Seq, msg); moveErr != nil {
+				s.respond(conn, msg, moveErr)
+			}
+
+		case *ViewportData:
+			s.respond(conn, msg, s.setViewport(conn, data.Width, data.Height))
+
+		case *ChatData:
+			if player == nil {
+				s.respond(conn, msg, &engine.GameError{Code: engine.ERR_NOT_JOINED, Reason: "join before chatting"})
+				continue
+			}
+			now := time.Now()
+			if now.Sub(lastChat) < CHAT_INTERVAL {
+				s.respond(conn, msg, &engine.GameError{Code: ERR_RATE_LIMITED, Reason: fmt.Sprintf("chat at most once every %s", CHAT_INTERVAL)})
+				continue
+			}
+			lastChat = now
+			s.game.Touch(player.ID)
+			s.respond(conn, msg, s.chat(player, room, *data))
+
+		case *ShootData:
+			if player == nil {
+				s.respond(conn, msg, &engine.GameError{Code: engine.ERR_NOT_JOINED, Reason: "join before shooting"})
+				continue
+			}
+
+			shootErr := s.shoot(player.ID, data.Direction)
+			if shootErr == nil {
+				log.Printf("Player %s shot %s", player.Name, data.Direction)
+			}
+			s.respond(conn, msg, shootErr)
+
+		case *ReloadData:
+			if player == nil {
+				s.respond(conn, msg, &engine.GameError{Code: engine.ERR_NOT_JOINED, Reason: "join before reloading"})
+				continue
+			}
+			s.respond(conn, msg, s.game.Reload(player.ID))
+		}
+	}
+
+	if player != nil {
+		s.removeClient(conn)
+		log.Printf("Player %s left the game", player.Name)
+	}
+}
+
+func (s *Server) rejectJoin(conn clientConn, msg InboundMessage, err *engine.GameError) {
+	s.send(conn, Message{Type: "joinRejected", ID: msg.ID, Data: err})
+	if msg.ID != "" {
+		s.respond(conn, msg, err)
+	}
+}
+
+func ServeSchema(w http.ResponseWriter, r *http.Request) {
+	schema, err := ProtocolSchema()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.Write(schema)
+}
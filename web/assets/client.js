@@ -0,0 +1,738 @@
+const CLIENT_CAPABILITIES = ['binary', 'batch', 'prediction'];
+const FRAME_ENTITIES = 1;
+const FRAME_DELTA = 2;
+const RECLAIM_KEY = 'arena-reclaim:' + SERVER_CONFIG.room;
+const TOKEN_KEY = 'arena-token';
+const RECONNECT_DELAY_MS = 2000;
+const RECONNECT_ATTEMPTS = 15;
+const CHAT_LOG_SIZE = 50;
+const ITEM_NAMES = {
+	speed: 'Velocidade',
+	rapidFire: 'Tiro rápido',
+	shield: 'Escudo',
+	tripleShot: 'Tiro triplo',
+	invisibility: 'Invisibilidade',
+	ammo: 'Munição',
+	rifle: 'Fuzil',
+	shotgun: 'Espingarda'
+};
+const ITEM_DESCRIPTIONS = {
+	speed: 'move duas vezes mais rápido',
+	rapidFire: 'recarga pela metade',
+	shield: 'tiros não te atingem',
+	tripleShot: 'três balas em leque',
+	invisibility: 'os outros não te veem',
+	ammo: 'dois pentes extras',
+	rifle: 'pente grande e tiro rápido',
+	shotgun: 'três balas em leque, tiro lento'
+};
+const WEAPON_NAMES = {
+	pistol: 'Pistola',
+	rifle: 'Fuzil',
+	shotgun: 'Espingarda'
+};
+
+let socket;
+let myPlayerId = null;
+let protocolVersion = null;
+let pendingJoin = null;
+let joinData = null;
+let restarting = false;
+let reconnectAttempts = 0;
+let worldCells = null;
+let lastWorldText = null;
+let worldSize = { width: SERVER_CONFIG.worldWidth, height: SERVER_CONFIG.worldHeight };
+let mapWalls = [];
+let viewOrigin = { x: 0, y: 0 };
+let serverSelf = null;
+let pendingMoves = [];
+let moveSeq = 0;
+let ammoState = null;
+let reloadEndsAt = 0;
+let reloadTimer = null;
+
+function joinGame() {
+	const name = document.getElementById('playerName').value.trim();
+	const character = document.getElementById('playerCharacter').value.trim();
+	const spectatorCheckbox = document.getElementById('spectatorCheckbox');
+	const spectator = spectatorCheckbox !== null && spectatorCheckbox.checked;
+
+	if (!name) {
+		alert('Por favor, digite seu nome!');
+		return;
+	}
+
+	if (!spectator && (!character || character.length !== 1)) {
+		alert('Por favor, digite exatamente um caractere!');
+		return;
+	}
+
+	const credentials = {};
+	if (SERVER_CONFIG.auth === 'password') {
+		credentials.password = document.getElementById('playerPassword').value;
+		if (!credentials.password) {
+			alert('Por favor, digite a senha!');
+			return;
+		}
+	} else if (SERVER_CONFIG.auth === 'token') {
+		credentials.token = loginToken();
+		if (!credentials.token) {
+			alert('Acesse a arena pelo link do portal para entrar.');
+			return;
+		}
+	}
+
+	document.getElementById('joinForm').classList.add('hidden');
+	document.getElementById('gameArea').classList.remove('hidden');
+
+	if (spectator) {
+		document.getElementById('worldDisplay').classList.add('hidden');
+	} else {
+		document.getElementById('worldDisplay').classList.remove('hidden');
+	}
+
+	document.body.classList.add('fullscreen-world');
+	if (spectator) {
+		document.body.classList.add('spectator');
+	} else {
+		document.body.classList.remove('spectator');
+	}
+
+	joinData = Object.assign({
+		name: name,
+		character: character,
+		spectator: spectator,
+		viewport: measureViewport()
+	}, credentials);
+	pendingJoin = joinMessage();
+
+	if (socket && socket.readyState === WebSocket.OPEN) {
+		if (protocolVersion) {
+			socket.send(pendingJoin);
+			pendingJoin = null;
+		}
+		return;
+	}
+
+	connect();
+}
+
+// loginToken takes the token the portal put in the link's #token=
+// fragment, keeping it for this tab and out of the address bar.
+function loginToken() {
+	const match = window.location.hash.match(/token=([^&]+)/);
+	if (match) {
+		sessionStorage.setItem(TOKEN_KEY, decodeURIComponent(match[1]));
+		history.replaceState(null, '', window.location.pathname + window.location.search);
+	}
+	return sessionStorage.getItem(TOKEN_KEY);
+}
+
+// joinMessage carries the reclaim token from the last welcome, so the
+// server can resume our player if it restarted in between. The token is
+// kept per tab, so two tabs never claim the same player.
+function joinMessage() {
+	const data = Object.assign({}, joinData);
+	const reclaimToken = sessionStorage.getItem(RECLAIM_KEY);
+	if (reclaimToken) {
+		data.reclaimToken = reclaimToken;
+	}
+	return JSON.stringify({ type: 'join', data: data });
+}
+
+function connect() {
+	const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
+	socket = new WebSocket(protocol + '//' + window.location.host + '/ws?room=' + encodeURIComponent(SERVER_CONFIG.room));
+	socket.binaryType = 'arraybuffer';
+
+	socket.onopen = function() {
+		socket.send(JSON.stringify({
+			type: 'hello',
+			data: {
+				version: SERVER_CONFIG.protocolVersion,
+				capabilities: CLIENT_CAPABILITIES
+			}
+		}));
+	};
+
+	socket.onmessage = function(event) {
+		if (event.data instanceof ArrayBuffer) {
+			handleWorldFrame(new Uint8Array(event.data));
+			return;
+		}
+		const msg = JSON.parse(event.data);
+		handleMessage(msg);
+	};
+
+	socket.onclose = function(event) {
+		console.log('Connection closed');
+		if (event.reason === 'too many failed logins') {
+			alert('Muitas tentativas de entrada falharam! Atualize a página para tentar de novo.');
+			return;
+		}
+		if (restarting && reconnectAttempts < RECONNECT_ATTEMPTS) {
+			reconnectAttempts++;
+			setTimeout(reconnect, RECONNECT_DELAY_MS);
+			return;
+		}
+		alert('Conexão perdida! Por favor, atualize a página.');
+	};
+}
+
+function reconnect() {
+	protocolVersion = null;
+	pendingMoves = [];
+	pendingJoin = joinMessage();
+	connect();
+}
+
+let noticeTimer = null;
+
+function showNotice(text) {
+    const notice = document.getElementById('notice');
+    notice.textContent = text;
+    notice.classList.remove('hidden');
+    clearTimeout(noticeTimer);
+    noticeTimer = setTimeout(() => notice.classList.add('hidden'), 4000);
+}
+
+function handleMessage(msg) {
+    switch (msg.type) {
+        case 'hello':
+            protocolVersion = msg.data.version;
+            if (pendingJoin) {
+                socket.send(pendingJoin);
+                pendingJoin = null;
+            }
+            break;
+
+        case 'batch':
+            msg.data.forEach(handleMessage);
+            break;
+
+        case 'welcome':
+            myPlayerId = msg.data.playerId;
+            sessionStorage.setItem(RECLAIM_KEY, msg.data.reclaimToken);
+            if (msg.data.reclaimed) {
+                showNotice('Partida retomada! Seu placar e posição foram recuperados.');
+            } else if (restarting) {
+                showNotice('Servidor reiniciado. A partida anterior não pôde ser retomada.');
+            }
+            restarting = false;
+            reconnectAttempts = 0;
+            worldCells = null;
+            mapWalls = (msg.data.map && msg.data.map.walls) || [];
+			renderWorld(msg.data.world);
+            updatePlayerList(msg.data.players);
+            updateLeaderboard(msg.data.leaderboard);
+            updateGlobalLeaderboard(msg.data.globalLeaderboard || []);
+            updateItemLegend(msg.data.items || []);
+            updateAmmo(msg.data.ammo || null);
+            break;
+
+        case 'ammo':
+            updateAmmo(msg.data);
+            break;
+
+        case 'worldUpdate':
+			renderWorld(msg.data);
+            break;
+
+        case 'playerList':
+            updatePlayerList(msg.data);
+            break;
+
+        case 'leaderboard':
+            updateLeaderboard(msg.data);
+            break;
+
+        case 'globalLeaderboard':
+            updateGlobalLeaderboard(msg.data || []);
+            break;
+
+        case 'chat':
+            addChatMessage(msg.data);
+            break;
+
+        case 'snapshot':
+            worldSize = { width: msg.data.width, height: msg.data.height };
+            viewOrigin = { x: msg.data.viewport.x, y: msg.data.viewport.y };
+            serverSelf = msg.data.players.find(p => p.id === myPlayerId) || null;
+            pendingMoves = pendingMoves.filter(input => input.seq > msg.data.lastSeq);
+            if (lastWorldText) {
+                renderWorld(lastWorldText);
+            }
+            break;
+
+        case 'configChanged':
+            worldSize = { width: msg.data.worldWidth, height: msg.data.worldHeight };
+            if (msg.data.map) {
+                mapWalls = msg.data.map.walls || [];
+                worldCells = null;
+                pendingMoves = [];
+                showNotice('Nova rodada! O mapa mudou e todos renasceram.');
+            } else {
+                showNotice('Configuração atualizada: ' + msg.data.changed.join(', '));
+            }
+            break;
+
+        case 'serverShutdown':
+            restarting = true;
+            showNotice('Servidor reiniciando... tentando reconectar.');
+            break;
+
+        case 'ack':
+            break;
+
+        case 'nack':
+            if (msg.id && msg.id.startsWith('move-')) {
+                const seq = parseInt(msg.id.substring(5), 10);
+                pendingMoves = pendingMoves.filter(input => input.seq !== seq);
+            }
+            if (msg.data.requestType === 'chat') {
+                showNotice(chatErrorMessages[msg.data.code] || 'Mensagem não enviada: ' + msg.data.reason);
+            }
+            console.warn('Servidor recusou ' + (msg.data.requestType || 'mensagem') + ': ' + msg.data.code + ' - ' + msg.data.reason);
+            break;
+
+        case 'error':
+            if (msg.data.code === 'out_of_ammo') {
+                showNotice('Sem munição! Procure uma caixa de munição: )');
+            }
+            console.warn('Servidor recusou ' + (msg.data.requestType || 'mensagem') + ': ' + msg.data.code + ' - ' + msg.data.reason);
+            break;
+
+        case 'idle':
+            if (msg.data.action === 'spectate') {
+                alert('Você ficou inativo por ' + msg.data.timeoutSeconds + 's e agora é espectador. Entre de novo para voltar a jogar.');
+                showJoinForm();
+            } else {
+                alert('Você ficou inativo por ' + msg.data.timeoutSeconds + 's e foi desconectado.');
+            }
+            break;
+
+        case 'joinRejected':
+            if (msg.data.code === 'auth_failed') {
+                sessionStorage.removeItem(TOKEN_KEY);
+            }
+            showJoinForm();
+            alert(joinRejectedMessages[msg.data.code] || msg.data.reason);
+            break;
+    }
+}
+
+const joinRejectedMessages = {
+	already_joined: 'Você já entrou no jogo.',
+	auth_failed: 'Falha na autenticação! Verifique a senha ou entre novamente pelo portal.',
+	invalid_name: 'Nome inválido! Use de 1 a 15 letras, números, espaços, _ - ou .',
+	name_taken: 'Este nome já está em uso!',
+	invalid_character: 'Caractere inválido! Use um único símbolo visível (exceto * | - + # ~ ! [ ^ / ) { }).',
+	character_taken: 'Este caractere já está em uso!'
+};
+
+const chatErrorMessages = {
+	rate_limited: 'Calma! Espere um pouco antes de mandar outra mensagem.',
+	unavailable: 'O chat entre salas está indisponível no momento.'
+};
+
+function showJoinForm() {
+	myPlayerId = null;
+	document.getElementById('joinForm').classList.remove('hidden');
+	document.getElementById('gameArea').classList.add('hidden');
+	document.body.classList.remove('fullscreen-world', 'spectator');
+}
+
+function readUvarint(bytes, reader) {
+	let value = 0;
+	let scale = 1;
+	while (true) {
+		const b = bytes[reader.offset++];
+		value += (b & 0x7f) * scale;
+		if (b < 0x80) {
+			return value;
+		}
+		scale *= 128;
+	}
+}
+
+function isWall(x, y) {
+	if (worldSize && (x < 0 || y < 0 || x >= worldSize.width || y >= worldSize.height)) {
+		return true;
+	}
+	return mapWalls.some(w => x >= w.x && x < w.x + w.width && y >= w.y && y < w.y + w.height);
+}
+
+function handleWorldFrame(bytes) {
+	const reader = { offset: 1 };
+	const width = readUvarint(bytes, reader);
+	const height = readUvarint(bytes, reader);
+	viewOrigin = { x: readUvarint(bytes, reader), y: readUvarint(bytes, reader) };
+
+	if (bytes[0] === FRAME_ENTITIES) {
+		worldCells = new Uint8Array(width * height).fill(32);
+		for (const wall of mapWalls) {
+			for (let y = Math.max(wall.y, viewOrigin.y); y < Math.min(wall.y + wall.height, viewOrigin.y + height); y++) {
+				for (let x = Math.max(wall.x, viewOrigin.x); x < Math.min(wall.x + wall.width, viewOrigin.x + width); x++) {
+					worldCells[(y - viewOrigin.y) * width + x - viewOrigin.x] = 35;
+				}
+			}
+		}
+		let cell = 0;
+		const playerCount = readUvarint(bytes, reader);
+		for (let i = 0; i < playerCount; i++) {
+			cell += readUvarint(bytes, reader);
+			worldCells[cell] = bytes[reader.offset];
+			reader.offset += 2;
+		}
+		cell = 0;
+		const bulletCount = readUvarint(bytes, reader);
+		for (let i = 0; i < bulletCount; i++) {
+			cell += readUvarint(bytes, reader);
+			if (worldCells[cell] === 32) {
+				worldCells[cell] = 42;
+			}
+		}
+		cell = 0;
+		const itemCount = reader.offset < bytes.length ? readUvarint(bytes, reader) : 0;
+		for (let i = 0; i < itemCount; i++) {
+			cell += readUvarint(bytes, reader);
+			if (worldCells[cell] === 32) {
+				worldCells[cell] = bytes[reader.offset];
+			}
+			reader.offset++;
+		}
+	} else if (bytes[0] === FRAME_DELTA) {
+		if (!worldCells || worldCells.length !== width * height) {
+			return;
+		}
+		let next = reader.offset + Math.ceil(width * height / 8);
+		for (let i = 0; i < worldCells.length; i++) {
+			if (bytes[reader.offset + (i >> 3)] & (1 << (i & 7))) {
+				worldCells[i] = bytes[next++];
+			}
+		}
+	} else {
+		return;
+	}
+
+	const border = '+' + '-'.repeat(width) + '+\n';
+	let text = border;
+	for (let y = 0; y < height; y++) {
+		text += '|' + String.fromCharCode.apply(null, worldCells.subarray(y * width, (y + 1) * width)) + '|\n';
+	}
+	renderWorld(text + border);
+}
+
+function predictedPosition() {
+	if (!serverSelf || serverSelf.dead || !worldSize) {
+		return null;
+	}
+	let x = serverSelf.x;
+	let y = serverSelf.y;
+	for (const input of pendingMoves) {
+		let nx = x;
+		let ny = y;
+		if (input.direction === 'up') ny = Math.max(0, y - 1);
+		if (input.direction === 'down') ny = Math.min(worldSize.height - 1, y + 1);
+		if (input.direction === 'left') nx = Math.max(0, x - 1);
+		if (input.direction === 'right') nx = Math.min(worldSize.width - 1, x + 1);
+		if (!isWall(nx, ny)) {
+			x = nx;
+			y = ny;
+		}
+	}
+	return { x: x, y: y };
+}
+
+function applyPrediction(worldText) {
+	const predicted = predictedPosition();
+	if (!predicted || (predicted.x === serverSelf.x && predicted.y === serverSelf.y)) {
+		return worldText;
+	}
+
+	const lines = worldText.split('\n');
+	const setCell = (x, y, ch) => {
+		const line = lines[y + 1];
+		if (line && x >= 0 && y >= 0 && x + 1 < line.length - 1) {
+			lines[y + 1] = line.substring(0, x + 1) + ch + line.substring(x + 2);
+		}
+	};
+	const px = predicted.x - viewOrigin.x;
+	const py = predicted.y - viewOrigin.y;
+	const target = lines[py + 1] ? lines[py + 1][px + 1] : null;
+	if (target !== ' ') {
+		return worldText;
+	}
+	setCell(serverSelf.x - viewOrigin.x, serverSelf.y - viewOrigin.y, ' ');
+	setCell(px, py, serverSelf.character);
+	return lines.join('\n');
+}
+
+function renderWorld(worldText) {
+	lastWorldText = worldText;
+	worldText = applyPrediction(worldText);
+
+	if (!myPlayerId) {
+		document.getElementById('world').textContent = worldText;
+		return;
+	}
+
+	const esc = (s) => s.replace(/&/g, '&amp;').replace(/</g, '&lt;').replace(/>/g, '&gt;');
+	let myChar = null;
+	const playersDiv = document.getElementById('players');
+	const worldEsc = esc(worldText);
+
+	let html = worldEsc.replace(/\*/g, '<span class="bullet">*</span>');
+
+	const playerItems = Array.from(document.querySelectorAll('#players .player-item'));
+	for (const item of playerItems) {
+		if (item.textContent && item.textContent.includes('(you)')) {
+
+		}
+	}
+
+	document.getElementById('world').innerHTML = html;
+}
+
+function measureViewport() {
+	const probe = document.createElement('pre');
+	probe.style.cssText = 'position:absolute;visibility:hidden;font-size:12px;line-height:1.1;margin:0';
+	probe.textContent = 'M';
+	document.body.appendChild(probe);
+	const cell = probe.getBoundingClientRect();
+	document.body.removeChild(probe);
+
+	return {
+		width: Math.max(1, Math.floor((window.innerWidth - 40) / cell.width) - 2),
+		height: Math.max(1, Math.floor((window.innerHeight - 40) / cell.height) - 2)
+	};
+}
+
+let resizeTimer = null;
+window.addEventListener('resize', function() {
+	clearTimeout(resizeTimer);
+	resizeTimer = setTimeout(function() {
+		if (myPlayerId && socket && socket.readyState === WebSocket.OPEN) {
+			socket.send(JSON.stringify({ type: 'viewport', data: measureViewport() }));
+		}
+	}, 250);
+});
+
+document.addEventListener('DOMContentLoaded', function() {
+	const spectatorCheckbox = document.getElementById('spectatorCheckbox');
+	const charInput = document.getElementById('playerCharacter');
+	if (spectatorCheckbox) {
+		spectatorCheckbox.addEventListener('change', function() {
+			if (spectatorCheckbox.checked) {
+				charInput.disabled = true;
+				charInput.value = '';
+			} else {
+				charInput.disabled = false;
+			}
+		});
+	}
+});
+
+function updatePlayerList(players) {
+	const playersDiv = document.getElementById('players');
+	playersDiv.innerHTML = '';
+
+	players.forEach(player => {
+		const playerDiv = document.createElement('div');
+		playerDiv.className = 'player-item';
+		const effects = Object.keys(player.effects || {}).map(kind => (ITEM_NAMES[kind] || kind) + ' ' + Math.ceil(player.effects[kind] / 1000) + 's');
+		playerDiv.textContent = player.character + ' - ' + player.name + ' (' + player.kills + '/' + player.deaths + ') ' + player.status + (player.rtt ? ' ' + player.rtt + 'ms' : '') + (effects.length ? ' [' + effects.join(', ') + ']' : '');
+		playersDiv.appendChild(playerDiv);
+	});
+}
+
+function updateItemLegend(items) {
+	const legendDiv = document.getElementById('itemLegend');
+	legendDiv.innerHTML = '';
+
+	items.forEach(item => {
+		const itemDiv = document.createElement('div');
+		itemDiv.className = 'legend-item';
+		itemDiv.textContent = item.glyph + ' ' + (ITEM_NAMES[item.kind] || item.kind) + (ITEM_DESCRIPTIONS[item.kind] ? ': ' + ITEM_DESCRIPTIONS[item.kind] : '');
+		legendDiv.appendChild(itemDiv);
+	});
+}
+
+function updateAmmo(state) {
+	ammoState = state;
+	reloadEndsAt = state && state.reloadMs ? Date.now() + state.reloadMs : 0;
+	clearInterval(reloadTimer);
+	if (reloadEndsAt) {
+		reloadTimer = setInterval(renderAmmo, 100);
+	}
+	renderAmmo();
+}
+
+function renderAmmo() {
+	const hud = document.getElementById('ammoHud');
+	if (!ammoState) {
+		hud.textContent = '';
+		return;
+	}
+	const weapon = WEAPON_NAMES[ammoState.weapon] || ammoState.weapon;
+	let text = weapon + ': ';
+	if (!ammoState.magazine) {
+		text += '∞';
+	} else {
+		text += ammoState.ammo + '/' + ammoState.magazine + ' | Reserva: ' + ammoState.spareAmmo;
+	}
+	const left = reloadEndsAt - Date.now();
+	if (left > 0) {
+		text += ' | Recarregando... ' + (left / 1000).toFixed(1) + 's';
+	} else {
+		clearInterval(reloadTimer);
+		if (ammoState.magazine && ammoState.ammo < ammoState.magazine && ammoState.spareAmmo > 0) {
+			text += ' | R para recarregar';
+		}
+	}
+	hud.textContent = text;
+	hud.classList.toggle('empty', ammoState.magazine > 0 && ammoState.ammo === 0 && ammoState.spareAmmo === 0);
+}
+
+function updateLeaderboard(leaderboard) {
+    const leaderboardDiv = document.getElementById('leaderboard');
+    leaderboardDiv.innerHTML = '';
+
+	leaderboard.forEach(player => {
+		const playerDiv = document.createElement('div');
+		playerDiv.className = 'leaderboard-item';
+		playerDiv.textContent = player.rank + '. ' + player.character + ' ' + player.name + ' - ' + player.kills + 'K/' + player.deaths + 'D (KDR: ' + player.kdr + ')';
+		leaderboardDiv.appendChild(playerDiv);
+	});
+}
+
+function updateGlobalLeaderboard(leaderboard) {
+	const leaderboardDiv = document.getElementById('globalLeaderboard');
+	leaderboardDiv.innerHTML = '';
+
+	leaderboard.forEach(player => {
+		const playerDiv = document.createElement('div');
+		playerDiv.className = 'leaderboard-item';
+		playerDiv.textContent = player.rank + '. ' + player.character + ' ' + player.name + ' [' + player.room + '] - ' + player.kills + 'K/' + player.deaths + 'D (KDR: ' + player.kdr + ')';
+		leaderboardDiv.appendChild(playerDiv);
+	});
+}
+
+function addChatMessage(message) {
+	const chatLog = document.getElementById('chatLog');
+	const messageDiv = document.createElement('div');
+	messageDiv.className = 'chat-item' + (message.global ? ' global' : '');
+	const from = message.global ? '[' + message.room + '] ' : '';
+	messageDiv.textContent = from + message.character + ' ' + message.from + ': ' + message.text;
+	chatLog.appendChild(messageDiv);
+	while (chatLog.children.length > CHAT_LOG_SIZE) {
+		chatLog.removeChild(chatLog.firstChild);
+	}
+	chatLog.scrollTop = chatLog.scrollHeight;
+}
+
+function sendChat() {
+	const input = document.getElementById('chatInput');
+	const text = input.value.trim();
+	if (!text || !myPlayerId || !socket || socket.readyState !== WebSocket.OPEN) {
+		return;
+	}
+	socket.send(JSON.stringify({
+		type: 'chat',
+		id: 'chat-' + Date.now(),
+		data: { text: text, global: document.getElementById('chatGlobal').checked }
+	}));
+	input.value = '';
+}
+
+function reload() {
+    if (socket && socket.readyState === WebSocket.OPEN) {
+        socket.send(JSON.stringify({
+            type: 'reload',
+            data: {}
+        }));
+    }
+}
+
+function move(direction) {
+    if (socket && socket.readyState === WebSocket.OPEN) {
+        const seq = ++moveSeq;
+        socket.send(JSON.stringify({
+            type: 'move',
+            id: 'move-' + seq,
+            data: {
+                direction: direction,
+                seq: seq
+            }
+        }));
+        pendingMoves.push({ seq: seq, direction: direction });
+        if (lastWorldText) {
+            renderWorld(lastWorldText);
+        }
+    }
+}
+
+function shoot(direction) {
+    if (socket && socket.readyState === WebSocket.OPEN) {
+        socket.send(JSON.stringify({
+            type: 'shoot',
+            data: {
+                direction: direction
+            }
+        }));
+    }
+}
+
+document.addEventListener('keydown', function(event) {
+    if (event.target.tagName === 'INPUT') {
+        return;
+    }
+    if (myPlayerId) {
+        switch(event.key.toLowerCase()) {
+            case 'w':
+            case 'arrowup':
+                move('up');
+                event.preventDefault();
+                break;
+            case 's':
+            case 'arrowdown':
+                move('down');
+                event.preventDefault();
+                break;
+            case 'a':
+            case 'arrowleft':
+                move('left');
+                event.preventDefault();
+                break;
+            case 'd':
+            case 'arrowright':
+                move('right');
+                event.preventDefault();
+                break;
+            case 'i':
+                shoot('up');
+                event.preventDefault();
+                break;
+            case 'k':
+                shoot('down');
+                event.preventDefault();
+                break;
+            case 'j':
+                shoot('left');
+                event.preventDefault();
+                break;
+            case 'l':
+                shoot('right');
+                event.preventDefault();
+                break;
+            case 'r':
+                reload();
+                event.preventDefault();
+                break;
+        }
+    }
+});
+
+if (SERVER_CONFIG.auth === 'token') {
+	loginToken();
+}
@@ -0,0 +1,212 @@
+// Package web serves the browser client: an HTML template and its static
+// assets, embedded in the binary or read from disk in dev mode.
+package web
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ASSET_DIR   = "web/assets"
+	STATIC_PATH = "/static/"
+
+	INDEX_CACHE_CONTROL  = "no-cache"
+	STATIC_CACHE_CONTROL = "public, max-age=31536000, immutable"
+	DEV_CACHE_CONTROL    = "no-store"
+)
+
+//go:embed assets
+var embedded embed.FS
+
+// PageData holds one room's server values templated into the page.
+type PageData struct {
+	Room            string
+	Title           string
+	ProtocolVersion int
+	WorldWidth      int
+	WorldHeight     int
+	Modes           map[string]bool
+	// Auth is what joining takes: "", "password" or "token".
+	Auth string
+}
+
+type clientConfig struct {
+	Room            string          `json:"room"`
+	ProtocolVersion int             `json:"protocolVersion"`
+	WorldWidth      int             `json:"worldWidth"`
+	WorldHeight     int             `json:"worldHeight"`
+	Modes           map[string]bool `json:"modes"`
+	Auth            string          `json:"auth"`
+}
+
+type asset struct {
+	body []byte
+	etag string
+}
+
+type bundle struct {
+	index  map[string]asset
+	static map[string]asset
+}
+
+// Handler serves the page at / and its assets under /static/. The page is
+// rendered for the room in the room query parameter, or the default room.
+// Outside dev mode everything is rendered once at startup and revalidated
+// by ETag.
+type Handler struct {
+	pages       map[string]PageData
+	defaultRoom string
+	dev         bool
+	files       fs.FS
+	cached      *bundle
+	mutex       sync.RWMutex
+}
+
+// NewHandler serves the embedded assets, or ASSET_DIR re-read on every
+// request when dev is set. pages is keyed by room name.
+func NewHandler(pages map[string]PageData, defaultRoom string, dev bool) (*Handler, error) {
+	h := &Handler{pages: pages, defaultRoom: defaultRoom, dev: dev}
+	if dev {
+		h.files = os.DirFS(ASSET_DIR)
+		log.Printf("Serving client assets from %s", ASSET_DIR)
+		return h, nil
+	}
+
+	files, err := fs.Sub(embedded, "assets")
+	if err != nil {
+		return nil, err
+	}
+	h.files = files
+	if h.cached, err = h.load(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// SetPages replaces the server values, e.g. after a config reload.
+func (h *Handler) SetPages(pages map[string]PageData) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	previous := h.pages
+	h.pages = pages
+	if h.dev {
+		return nil
+	}
+	cached, err := h.load()
+	if err != nil {
+		h.pages = previous
+		return err
+	}
+	h.cached = cached
+	return nil
+}
+
+func newAsset(body []byte) asset {
+	sum := sha256.Sum256(body)
+	return asset{body: body, etag: hex.EncodeToString(sum[:8])}
+}
+
+func (h *Handler) load() (*bundle, error) {
+	b := &bundle{index: make(map[string]asset), static: make(map[string]asset)}
+	versions := make(map[string]string)
+
+	err := fs.WalkDir(h.files, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || name == "index.html" {
+			return err
+		}
+		body, err := fs.ReadFile(h.files, name)
+		if err != nil {
+			return err
+		}
+		b.static[name] = newAsset(body)
+		versions[name] = b.static[name].etag
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := template.ParseFS(h.files, "index.html")
+	if err != nil {
+		return nil, err
+	}
+	for room, data := range h.pages {
+		var page bytes.Buffer
+		err = tmpl.Execute(&page, struct {
+			PageData
+			Config clientConfig
+			Assets map[string]string
+		}{
+			PageData: data,
+			Config: clientConfig{
+				Room:            data.Room,
+				ProtocolVersion: data.ProtocolVersion,
+				WorldWidth:      data.WorldWidth,
+				WorldHeight:     data.WorldHeight,
+				Modes:           data.Modes,
+				Auth:            data.Auth,
+			},
+			Assets: versions,
+		})
+		if err != nil {
+			return nil, err
+		}
+		b.index[room] = newAsset(page.Bytes())
+	}
+	return b, nil
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mutex.RLock()
+	b := h.cached
+	var err error
+	if h.dev {
+		b, err = h.load()
+	}
+	h.mutex.RUnlock()
+	if err != nil {
+		log.Printf("Error loading client assets: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var name, cacheControl string
+	var a asset
+	var found bool
+	switch {
+	case r.URL.Path == "/" || r.URL.Path == "/index.html":
+		room := r.URL.Query().Get("room")
+		if room == "" {
+			room = h.defaultRoom
+		}
+		name, cacheControl = "index.html", INDEX_CACHE_CONTROL
+		a, found = b.index[room]
+	case strings.HasPrefix(r.URL.Path, STATIC_PATH):
+		name, cacheControl = path.Clean(strings.TrimPrefix(r.URL.Path, STATIC_PATH)), STATIC_CACHE_CONTROL
+		a, found = b.static[name]
+	}
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+
+	if h.dev {
+		cacheControl = DEV_CACHE_CONTROL
+	}
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("ETag", `"`+a.etag+`"`)
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(a.body))
+}
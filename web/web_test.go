@@ -0,0 +1,125 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestHandler(t *testing.T) *Handler {
+	t.Helper()
+
+	h, err := NewHandler(map[string]PageData{
+		"main": {
+			Room:            "main",
+			Title:           "Arena de teste",
+			ProtocolVersion: 3,
+			WorldWidth:      40,
+			WorldHeight:     20,
+			Modes:           map[string]bool{"spectator": false, "fogOfWar": true},
+		},
+		"duel": {
+			Room:            "duel",
+			Title:           "Duelo",
+			ProtocolVersion: 3,
+			WorldWidth:      30,
+			WorldHeight:     15,
+			Modes:           map[string]bool{"spectator": true},
+		},
+	}, "main", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func get(h http.Handler, path string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestIndexTemplate(t *testing.T) {
+	rec := get(newTestHandler(t), "/", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		"<title>Arena de teste</title>",
+		`"room":"main"`,
+		`"protocolVersion":3`,
+		`"worldWidth":40`,
+		`"fogOfWar":true`,
+		`/static/client.js?v=`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("page is missing %s", want)
+		}
+	}
+	if strings.Contains(body, "spectatorCheckbox") {
+		t.Error("spectator checkbox shown with spectating disabled")
+	}
+}
+
+func TestRoomPages(t *testing.T) {
+	h := newTestHandler(t)
+
+	body := get(h, "/?room=duel", nil).Body.String()
+	for _, want := range []string{"<title>Duelo</title>", `"room":"duel"`, `"worldWidth":30`, "spectatorCheckbox"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("duel page is missing %s", want)
+		}
+	}
+	if rec := get(h, "/?room=missing", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown room returned %d", rec.Code)
+	}
+}
+
+func TestCacheHeaders(t *testing.T) {
+	h := newTestHandler(t)
+
+	tests := []struct {
+		path         string
+		cacheControl string
+	}{
+		{"/", INDEX_CACHE_CONTROL},
+		{"/static/client.js", STATIC_CACHE_CONTROL},
+		{"/static/style.css", STATIC_CACHE_CONTROL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := get(h, tt.path, nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d", rec.Code)
+			}
+			if got := rec.Header().Get("Cache-Control"); got != tt.cacheControl {
+				t.Errorf("Cache-Control %q, want %q", got, tt.cacheControl)
+			}
+
+			etag := rec.Header().Get("ETag")
+			if etag == "" {
+				t.Fatal("no ETag")
+			}
+			rec = get(h, tt.path, http.Header{"If-None-Match": {etag}})
+			if rec.Code != http.StatusNotModified {
+				t.Errorf("revalidation returned %d, want %d", rec.Code, http.StatusNotModified)
+			}
+		})
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	h := newTestHandler(t)
+	for _, path := range []string{"/missing", "/static/missing.js", "/static/index.html", "/static/../web.go"} {
+		if rec := get(h, path, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("%s returned %d", path, rec.Code)
+		}
+	}
+}